@@ -0,0 +1,150 @@
+// Copyright 2013 Gary Burd
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+//go:build autobahn
+
+// This file is an integration test, not part of the normal `go test ./...`
+// run: it drives the connection this package builds against the Autobahn
+// TestSuite fuzzingclient, an external Python tool that is not vendored
+// here. Install it with `pip install autobahntestsuite`, then run with:
+//
+//	go test -tags autobahn -run TestAutobahnConformance -v ./...
+//
+// wstest runs as the fuzzing client and connects to the echo server
+// started below, running every case in sections 1-7 (framing, pings/pongs,
+// reserved bits, opcodes, fragmentation, UTF-8 handling, and close
+// handling) against it.
+// The report is written to ./autobahn-report and the test fails if any
+// case is not "OK" or "NON-STRICT".
+
+package websocket
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+)
+
+func autobahnEchoServer() *httptest.Server {
+	upgrader := Upgrader{ReadBufferSize: 1024, WriteBufferSize: 1024}
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		c, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer c.Close()
+		for {
+			messageType, r, err := c.NextReader()
+			if err != nil {
+				return
+			}
+			wr, err := c.NextWriter(messageType)
+			if err != nil {
+				return
+			}
+			if _, err := io.Copy(wr, r); err != nil {
+				return
+			}
+			if err := wr.Close(); err != nil {
+				return
+			}
+		}
+	}))
+}
+
+// TestAutobahnConformance runs the Autobahn TestSuite fuzzingclient
+// (sections 1-7) against this package's server-side Conn, via an
+// echo server build on top of Upgrader.
+func TestAutobahnConformance(t *testing.T) {
+	if _, err := exec.LookPath("wstest"); err != nil {
+		t.Skip("wstest (autobahntestsuite) not found in PATH; install with `pip install autobahntestsuite`")
+	}
+
+	srv := autobahnEchoServer()
+	defer srv.Close()
+
+	reportDir, err := filepath.Abs("autobahn-report")
+	if err != nil {
+		t.Fatal(err)
+	}
+	spec := map[string]interface{}{
+		"outdir": reportDir,
+		"servers": []map[string]string{
+			{"agent": "go-websocket", "url": "ws" + srv.URL[len("http"):]},
+		},
+		"cases":         []string{"1.*", "2.*", "3.*", "4.*", "5.*", "6.*", "7.*"},
+		"exclude-cases": []string{},
+	}
+	specFile, err := os.CreateTemp("", "autobahn-spec-*.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(specFile.Name())
+	if err := json.NewEncoder(specFile).Encode(spec); err != nil {
+		t.Fatal(err)
+	}
+	specFile.Close()
+
+	cmd := exec.Command("wstest", "-m", "fuzzingclient", "-s", specFile.Name())
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	if err := cmd.Run(); err != nil {
+		t.Fatalf("wstest fuzzingclient run failed: %v", err)
+	}
+
+	checkAutobahnReport(t, filepath.Join(reportDir, "index.json"))
+}
+
+// autobahnCaseResult is the per-case entry wstest writes into index.json,
+// keyed by agent then case ID; only the fields this test checks are
+// declared here.
+type autobahnCaseResult struct {
+	Behavior      string `json:"behavior"`
+	BehaviorClose string `json:"behaviorClose"`
+}
+
+// checkAutobahnReport parses the Autobahn TestSuite's index.json and fails
+// the test on any case whose behavior (or close-handshake behavior) is not
+// "OK" or "NON-STRICT" — cmd.Run() above only reports whether wstest itself
+// ran, not whether the cases it drove against this package's Conn passed.
+func checkAutobahnReport(t *testing.T, path string) {
+	t.Helper()
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("reading Autobahn report: %v", err)
+	}
+	var report map[string]map[string]autobahnCaseResult
+	if err := json.Unmarshal(data, &report); err != nil {
+		t.Fatalf("parsing Autobahn report: %v", err)
+	}
+
+	cases, ok := report["go-websocket"]
+	if !ok {
+		t.Fatalf("Autobahn report has no results for agent %q", "go-websocket")
+	}
+
+	for id, result := range cases {
+		for _, behavior := range []string{result.Behavior, result.BehaviorClose} {
+			if behavior != "OK" && behavior != "NON-STRICT" {
+				t.Errorf("case %s: behavior %q, want OK or NON-STRICT", id, behavior)
+			}
+		}
+	}
+}
@@ -0,0 +1,228 @@
+// Copyright 2013 Gary Burd
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package websocket
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"errors"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// ErrBadHandshake is returned when the server response to opening
+// handshake is invalid.
+var ErrBadHandshake = errors.New("websocket: bad handshake")
+
+// Dialer contains options for connecting to a WebSocket server.
+type Dialer struct {
+	// NetDial specifies the dial function for creating TCP connections. If
+	// NetDial is nil, net.Dial is used.
+	NetDial func(network, addr string) (net.Conn, error)
+
+	// NetDialContext specifies the dial function for creating TCP
+	// connections with a context. If NetDialContext is nil, NetDial is
+	// used instead. NetDialContext takes precedence over NetDial, mirroring
+	// the NetDialContext/NetDial pair on http.Transport.
+	NetDialContext func(ctx context.Context, network, addr string) (net.Conn, error)
+
+	// Proxy specifies a function to return the proxy to use for a given
+	// request, mirroring http.Transport.Proxy. The request passed to Proxy
+	// has a URL holding only the WebSocket URL's host and its scheme, with
+	// ws/wss rewritten to http/https; path and query are not included.
+	// Proxy returning a nil URL and nil error means no
+	// proxy is used. The returned URL's scheme selects the tunneling
+	// method: "socks5" or "socks5h" for a SOCKS5 proxy (RFC 1928), "http"
+	// or "https" for an HTTP CONNECT proxy; credentials in the URL's
+	// userinfo are used to authenticate with the proxy.
+	Proxy func(*http.Request) (*url.URL, error)
+
+	// TLSClientConfig specifies the TLS configuration to use with tls.Client.
+	// If nil, the default configuration is used.
+	TLSClientConfig *tls.Config
+
+	// HandshakeTimeout specifies the duration for the handshake to complete.
+	HandshakeTimeout time.Duration
+
+	// ReadBufferSize and WriteBufferSize specify I/O buffer sizes in
+	// bytes. If a buffer size is zero, then a default value of 4096 is
+	// used.
+	ReadBufferSize, WriteBufferSize int
+
+	// Subprotocols specifies the client's requested subprotocols.
+	Subprotocols []string
+
+	// EnableCompression specifies whether the client should offer the
+	// permessage-deflate extension (RFC 7692) to the server.
+	EnableCompression bool
+}
+
+// DefaultDialer is a dialer with all fields set to the default values.
+var DefaultDialer = &Dialer{}
+
+// Dial creates a new client connection. The URL u should use the ws or wss
+// scheme. The requestHeader specifies the additional headers to send in the
+// opening handshake request.
+func (d *Dialer) Dial(urlStr string, requestHeader http.Header) (*Conn, *http.Response, error) {
+	u, err := url.Parse(urlStr)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var network string
+	switch u.Scheme {
+	case "ws":
+		network = "tcp"
+		u.Scheme = "http"
+	case "wss":
+		network = "tcp"
+		u.Scheme = "https"
+	default:
+		return nil, nil, errors.New("websocket: bad URL scheme: " + u.Scheme)
+	}
+
+	hostPort := u.Host
+	if _, _, err := net.SplitHostPort(hostPort); err != nil {
+		if u.Scheme == "https" {
+			hostPort += ":443"
+		} else {
+			hostPort += ":80"
+		}
+	}
+
+	deadline := time.Time{}
+	ctx := context.Background()
+	if d.HandshakeTimeout != 0 {
+		deadline = time.Now().Add(d.HandshakeTimeout)
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithDeadline(ctx, deadline)
+		defer cancel()
+	}
+
+	var proxyURL *url.URL
+	if d.Proxy != nil {
+		proxyURL, err = d.Proxy(&http.Request{URL: &url.URL{Scheme: u.Scheme, Host: u.Host}})
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+
+	var netConn net.Conn
+	if proxyURL != nil {
+		netConn, err = d.dialProxy(ctx, proxyURL, hostPort)
+	} else {
+		netConn, err = d.dialNet(ctx, network, hostPort)
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	if !deadline.IsZero() {
+		netConn.SetDeadline(deadline)
+	}
+	if u.Scheme == "https" {
+		cfg := d.TLSClientConfig
+		if cfg == nil {
+			cfg = &tls.Config{}
+		}
+		if cfg.ServerName == "" {
+			host, _, err := net.SplitHostPort(hostPort)
+			if err != nil {
+				host = hostPort
+			}
+			cfg = cfg.Clone()
+			cfg.ServerName = host
+		}
+		tlsConn := tls.Client(netConn, cfg)
+		if err := tlsConn.Handshake(); err != nil {
+			netConn.Close()
+			return nil, nil, err
+		}
+		netConn = tlsConn
+	}
+
+	didSucceed := false
+	defer func() {
+		if !didSucceed {
+			netConn.Close()
+		}
+	}()
+
+	challengeKey, err := generateChallengeKey()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	req := &http.Request{
+		Method:     "GET",
+		URL:        u,
+		Proto:      "HTTP/1.1",
+		ProtoMajor: 1,
+		ProtoMinor: 1,
+		Header:     make(http.Header),
+		Host:       u.Host,
+	}
+	for k, vs := range requestHeader {
+		req.Header[k] = vs
+	}
+	req.Header.Set("Upgrade", "websocket")
+	req.Header.Set("Connection", "Upgrade")
+	req.Header.Set("Sec-WebSocket-Key", challengeKey)
+	req.Header.Set("Sec-WebSocket-Version", "13")
+	if len(d.Subprotocols) > 0 {
+		req.Header.Set("Sec-WebSocket-Protocol", strings.Join(d.Subprotocols, ", "))
+	}
+	if d.EnableCompression {
+		req.Header.Set("Sec-WebSocket-Extensions", permessageDeflateOfferHeader)
+	}
+
+	if err := req.Write(netConn); err != nil {
+		return nil, nil, err
+	}
+
+	br := bufio.NewReader(netConn)
+	resp, err := http.ReadResponse(br, req)
+	if err != nil {
+		return nil, nil, err
+	}
+	if resp.StatusCode != http.StatusSwitchingProtocols ||
+		!tokenListContainsValue(resp.Header.Get("Connection"), "upgrade") ||
+		!tokenListContainsValue(resp.Header.Get("Upgrade"), "websocket") ||
+		resp.Header.Get("Sec-WebSocket-Accept") != computeAcceptKey(challengeKey) {
+		return nil, resp, ErrBadHandshake
+	}
+	if br.Buffered() > 0 {
+		return nil, resp, errors.New("websocket: client received unexpected data before handshake is complete")
+	}
+
+	if !deadline.IsZero() {
+		netConn.SetDeadline(time.Time{})
+	}
+
+	c := newConn(netConn, false, d.ReadBufferSize, d.WriteBufferSize)
+	c.subprotocol = resp.Header.Get("Sec-WebSocket-Protocol")
+
+	if d.EnableCompression {
+		if _, ok := permessageDeflateOffer(parseExtensionHeader(resp.Header.Get("Sec-WebSocket-Extensions"))); ok {
+			c.permessageDeflate = true
+		}
+	}
+
+	didSucceed = true
+	return c, resp, nil
+}
@@ -0,0 +1,95 @@
+// Copyright 2013 Gary Burd
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package websocket
+
+import (
+	"bytes"
+	"compress/flate"
+	"io"
+)
+
+// deflateTail is the 4-byte trailer that RFC 7692 Section 7.2.1 says a
+// permessage-deflate sender always produces (an empty DEFLATE stored
+// block) and must strip before putting the message on the wire; a
+// receiver must append it back before handing the stream to a DEFLATE
+// decompressor.
+var deflateTail = [4]byte{0x00, 0x00, 0xff, 0xff}
+
+// decompressionTail is what newDecompressionReader actually appends: the
+// RFC 7692 tail, followed by a synthetic empty final stored block (BFINAL=1).
+// compress/flate.Writer.Flush never sets BFINAL, since more messages may
+// follow on the same stream, so without this extra block flate.Reader sees
+// the end of input mid-stream and reports io.ErrUnexpectedEOF instead of a
+// clean io.EOF once it has drained a message's payload.
+var decompressionTail = append(append([]byte{}, deflateTail[:]...), 0x01, 0x00, 0x00, 0xff, 0xff)
+
+// newDecompressionReader returns a reader that inflates a permessage-deflate
+// payload read from r.
+func newDecompressionReader(r io.Reader) io.ReadCloser {
+	return flate.NewReader(io.MultiReader(r, bytes.NewReader(decompressionTail)))
+}
+
+// compressWriter deflates everything written to it and forwards the
+// compressed bytes to dst, withholding the last len(deflateTail) bytes at
+// all times so that Close can drop them once it is known they are in fact
+// the trailer (rather than message content) without having to buffer the
+// whole message.
+type compressWriter struct {
+	dst  io.Writer
+	fw   *flate.Writer
+	held []byte
+}
+
+func newCompressionWriter(dst io.Writer, level int) (*compressWriter, error) {
+	cw := &compressWriter{dst: dst}
+	fw, err := flate.NewWriter(writerFunc(cw.hold), level)
+	if err != nil {
+		return nil, err
+	}
+	cw.fw = fw
+	return cw, nil
+}
+
+type writerFunc func(p []byte) (int, error)
+
+func (f writerFunc) Write(p []byte) (int, error) { return f(p) }
+
+func (cw *compressWriter) hold(p []byte) (int, error) {
+	n := len(p)
+	buf := append(cw.held, p...)
+	if len(buf) <= len(deflateTail) {
+		cw.held = buf
+		return n, nil
+	}
+	send := buf[:len(buf)-len(deflateTail)]
+	cw.held = append([]byte(nil), buf[len(buf)-len(deflateTail):]...)
+	if _, err := cw.dst.Write(send); err != nil {
+		return 0, err
+	}
+	return n, nil
+}
+
+func (cw *compressWriter) Write(p []byte) (int, error) { return cw.fw.Write(p) }
+
+// Close flushes the compressor, producing the RFC 7692 tail as the last
+// bytes of the stream, and discards whatever is still held back (which is
+// exactly that tail).
+func (cw *compressWriter) Close() error {
+	if err := cw.fw.Flush(); err != nil {
+		return err
+	}
+	cw.held = nil
+	return nil
+}
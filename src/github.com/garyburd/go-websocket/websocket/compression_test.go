@@ -0,0 +1,141 @@
+// Copyright 2013 Gary Burd
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package websocket
+
+import (
+	"bytes"
+	"io/ioutil"
+	"testing"
+)
+
+// TestPermessageDeflateFraming writes a sequence of compressed messages,
+// the largest of which a small write buffer fragments across several
+// frames, and confirms the peer reads back the original payloads.
+func TestPermessageDeflateFraming(t *testing.T) {
+	messages := [][]byte{
+		[]byte(""),
+		[]byte("hello"),
+		bytes.Repeat([]byte("compress me please "), 200),
+	}
+
+	var connBuf bytes.Buffer
+	wc := newConn(fakeNetConn{Reader: nil, Writer: &connBuf}, false, 1024, 16)
+	wc.permessageDeflate = true
+
+	for _, m := range messages {
+		w, err := wc.NextWriter(OpText)
+		if err != nil {
+			t.Fatalf("NextWriter() returned %v", err)
+		}
+		if _, err := w.Write(m); err != nil {
+			t.Fatalf("Write() returned %v", err)
+		}
+		if err := w.Close(); err != nil {
+			t.Fatalf("Close() returned %v", err)
+		}
+	}
+
+	rc := newConn(fakeNetConn{Reader: bytes.NewReader(connBuf.Bytes()), Writer: ioutil.Discard}, true, 1024, 1024)
+	rc.permessageDeflate = true
+
+	for _, want := range messages {
+		opCode, r, err := rc.NextReader()
+		if err != nil {
+			t.Fatalf("NextReader() returned %v", err)
+		}
+		if opCode != OpText {
+			t.Fatalf("NextReader() returned opCode %d, want OpText", opCode)
+		}
+		got, err := ioutil.ReadAll(r)
+		if err != nil {
+			t.Fatalf("ReadAll() returned %v", err)
+		}
+		if !bytes.Equal(got, want) {
+			t.Fatalf("message mismatch: got %q, want %q", got, want)
+		}
+	}
+}
+
+// TestPermessageDeflateDisableCompression checks that a message opted out
+// of compression via DisableCompression is sent as a plain, uncompressed
+// frame even though permessage-deflate is active on the connection.
+func TestPermessageDeflateDisableCompression(t *testing.T) {
+	var connBuf bytes.Buffer
+	wc := newConn(fakeNetConn{Reader: nil, Writer: &connBuf}, false, 1024, 1024)
+	wc.permessageDeflate = true
+
+	w, err := wc.NextWriter(OpBinary)
+	if err != nil {
+		t.Fatalf("NextWriter() returned %v", err)
+	}
+	w.(CompressionWriter).DisableCompression()
+	want := []byte("not compressed")
+	if _, err := w.Write(want); err != nil {
+		t.Fatalf("Write() returned %v", err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("Close() returned %v", err)
+	}
+
+	rc := newConn(fakeNetConn{Reader: bytes.NewReader(connBuf.Bytes()), Writer: ioutil.Discard}, true, 1024, 1024)
+	rc.permessageDeflate = true
+	_, r, err := rc.NextReader()
+	if err != nil {
+		t.Fatalf("NextReader() returned %v", err)
+	}
+	got, err := ioutil.ReadAll(r)
+	if err != nil {
+		t.Fatalf("ReadAll() returned %v", err)
+	}
+	if !bytes.Equal(got, want) {
+		t.Fatalf("message mismatch: got %q, want %q", got, want)
+	}
+}
+
+// TestAcceptPermessageDeflateContextTakeover covers both context-takeover
+// modes the client may offer: a bare offer that allows context takeover,
+// and one that declares client_no_context_takeover and names
+// client_max_window_bits/server_max_window_bits. Either way this
+// implementation never keeps a sliding window between messages, so its
+// response always declares both *_no_context_takeover parameters.
+func TestAcceptPermessageDeflateContextTakeover(t *testing.T) {
+	tests := []struct {
+		name   string
+		offer  string
+		window bool
+	}{
+		{"context takeover allowed", "permessage-deflate", false},
+		{"no context takeover with window bits", "permessage-deflate; client_no_context_takeover; client_max_window_bits=10; server_max_window_bits=12", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			offer, ok := permessageDeflateOffer(parseExtensionHeader(tt.offer))
+			if !ok {
+				t.Fatalf("permessageDeflateOffer() did not find an offer in %q", tt.offer)
+			}
+			resp := acceptPermessageDeflate(offer)
+			if !bytes.Contains([]byte(resp), []byte("client_no_context_takeover")) {
+				t.Errorf("response %q missing client_no_context_takeover", resp)
+			}
+			if !bytes.Contains([]byte(resp), []byte("server_no_context_takeover")) {
+				t.Errorf("response %q missing server_no_context_takeover", resp)
+			}
+			if tt.window && !bytes.Contains([]byte(resp), []byte("max_window_bits=")) {
+				t.Errorf("response %q dropped the offered max_window_bits", resp)
+			}
+		})
+	}
+}
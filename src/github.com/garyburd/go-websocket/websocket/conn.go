@@ -0,0 +1,820 @@
+// Copyright 2013 Gary Burd
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package websocket
+
+import (
+	"bufio"
+	"compress/flate"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"io"
+	"io/ioutil"
+	"math"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+const (
+	// Frame header bits from Section 5.2 of RFC 6455.
+	finBit  = 1 << 7
+	rsv1Bit = 1 << 6 // set on the first frame of a permessage-deflate compressed message, RFC 7692 Section 6
+	rsv2Bit = 1 << 5 // reserved for extensions this package does not negotiate; must never be set
+	rsv3Bit = 1 << 4 // reserved for extensions this package does not negotiate; must never be set
+	maskBit = 1 << 7
+
+	maxControlFramePayloadSize = 125
+
+	defaultReadBufferSize  = 4096
+	defaultWriteBufferSize = 4096
+)
+
+// Opcodes defined in Section 11.8 of RFC 6455.
+const (
+	OpContinuation = 0
+	OpText         = 1
+	OpBinary       = 2
+	OpClose        = 8
+	OpPing         = 9
+	OpPong         = 10
+)
+
+// Close codes defined in Section 11.7 of RFC 6455.
+const (
+	CloseNormalClosure           = 1000
+	CloseGoingAway               = 1001
+	CloseProtocolError           = 1002
+	CloseUnsupportedData         = 1003
+	CloseNoStatusReceived        = 1005
+	CloseAbnormalClosure         = 1006
+	CloseInvalidFramePayloadData = 1007
+	ClosePolicyViolation         = 1008
+	CloseMessageTooBig           = 1009
+	CloseMandatoryExtension      = 1010
+	CloseInternalServerErr       = 1011
+	CloseTLSHandshake            = 1015
+)
+
+// CloseError describes a close message received from the peer. NextReader
+// and the message reader it returns report it, as the error itself, once a
+// close frame has been consumed.
+type CloseError struct {
+	Code int
+	Text string
+}
+
+func (e *CloseError) Error() string {
+	s := "websocket: close " + strconv.Itoa(e.Code)
+	if e.Text != "" {
+		s += ": " + e.Text
+	}
+	return s
+}
+
+// ErrReadLimit is returned by the connection's Read methods when the read
+// limit set with SetReadLimit is exceeded, and by setReadRemaining when a
+// decoded frame length cannot be represented as a non-negative int64 (for
+// example because a peer advertised a 64-bit extended length with the high
+// bit set).
+var ErrReadLimit = errors.New("websocket: read limit exceeded")
+
+// ErrCloseSent is returned when the application writes a message to the
+// connection after a close message has already been sent.
+var ErrCloseSent = errors.New("websocket: close sent")
+
+// ErrBadWriteOpCode is returned when an unsupported opcode is passed to a
+// write method.
+var ErrBadWriteOpCode = errors.New("websocket: bad write message type")
+
+func isControl(frameType int) bool {
+	return frameType == OpClose || frameType == OpPing || frameType == OpPong
+}
+
+// validCloseCode reports whether code is one a peer is allowed to put on
+// the wire in a close frame, per Section 7.4 of RFC 6455: codes below
+// 1000 are never valid, 1004-1006 and 1015 are reserved for internal use
+// by an implementation and must never be sent, 1012-2999 outside the
+// defined range are unassigned, and 3000-4999 are reserved for
+// libraries/frameworks and private use respectively.
+func validCloseCode(code int) bool {
+	switch {
+	case code >= 1000 && code <= 1003:
+		return true
+	case code >= 1007 && code <= 1011:
+		return true
+	case code >= 3000 && code <= 4999:
+		return true
+	default:
+		return false
+	}
+}
+
+func isData(frameType int) bool {
+	return frameType == OpText || frameType == OpBinary
+}
+
+func maskBytes(key [4]byte, pos int, b []byte) int {
+	for i := range b {
+		b[i] ^= key[pos&3]
+		pos++
+	}
+	return pos & 3
+}
+
+// newMaskKey returns a masking key read from a source of randomness. It is
+// a variable, rather than a direct crypto/rand call, so tests can pin it.
+var newMaskKey = func() [4]byte {
+	var key [4]byte
+	if _, err := rand.Read(key[:]); err != nil {
+		panic("websocket: failed to read masking key: " + err.Error())
+	}
+	return key
+}
+
+const writeWait = time.Second
+
+// Conn represents a WebSocket connection.
+type Conn struct {
+	conn     net.Conn
+	isServer bool
+
+	subprotocol  string
+	writeBufSize int
+
+	// writeMu serializes the physical writes made to conn so that a
+	// control frame sent via WriteControl can never tear a data frame
+	// written by an in-flight messageWriter.
+	writeMu sync.Mutex
+
+	// mu enforces that at most one NextWriter is open at a time; it is
+	// acquired by NextWriter and released when the writer is closed.
+	mu chan struct{}
+
+	closeSent bool
+	writeErr  error
+
+	// permessageDeflate is true once the permessage-deflate extension
+	// (RFC 7692) has been negotiated for this connection by the Upgrader
+	// or Dialer handshake. enableWriteCompression lets the application
+	// turn compression of outgoing messages on or off afterwards;
+	// compressionLevel is the compress/flate level used to do it. Each
+	// message is compressed independently of the others (i.e. this
+	// implementation always behaves as though both
+	// *_no_context_takeover parameters were negotiated), since
+	// compress/flate does not expose the custom window sizes that real
+	// context takeover would require.
+	permessageDeflate      bool
+	enableWriteCompression bool
+	compressionLevel       int
+
+	// Read fields
+	br             *bufio.Reader
+	readErr        error
+	readRemaining  int64 // bytes remaining in the current frame.
+	readFinal      bool  // true once the current message has no more fragments.
+	readLength     int64 // bytes read so far for the current message.
+	readLimit      int64 // maximum message size, 0 means unlimited.
+	readMasked     bool
+	readMaskPos    int
+	readMaskKey    [4]byte
+	readDecompress bool // true if the current message was sent with RSV1 set.
+
+	handlePing  func(string) error
+	handlePong  func(string) error
+	handleClose func(int, string) error
+
+	// writeQueue is non-nil once EnableWriteQueue has put the connection
+	// into asynchronous write mode; see write_queue.go. writeQueue itself
+	// is never closed, so a send on it can never race a close - Close
+	// instead closes writeQueueDone to tell writeQueueLoop to stop and to
+	// unblock any pending send.
+	writeQueue       chan queuedMessage
+	writeQueueDone   chan struct{}
+	writeQueueMu     sync.Mutex
+	writeQueueErr    error
+	writeQueueClosed bool
+}
+
+func newConn(conn net.Conn, isServer bool, readBufSize, writeBufSize int) *Conn {
+	if readBufSize == 0 {
+		readBufSize = defaultReadBufferSize
+	}
+	if writeBufSize == 0 {
+		writeBufSize = defaultWriteBufferSize
+	}
+
+	c := &Conn{
+		conn:                   conn,
+		isServer:               isServer,
+		br:                     bufio.NewReaderSize(conn, readBufSize),
+		mu:                     make(chan struct{}, 1),
+		readFinal:              true,
+		writeBufSize:           writeBufSize,
+		enableWriteCompression: true,
+		compressionLevel:       flate.DefaultCompression,
+	}
+	c.mu <- struct{}{}
+	c.handlePong = func(string) error { return nil }
+	c.handlePing = func(message string) error {
+		return c.WriteControl(OpPong, []byte(message), time.Now().Add(writeWait))
+	}
+	c.handleClose = func(code int, text string) error {
+		return c.WriteControl(OpClose, FormatCloseMessage(code, ""), time.Now().Add(writeWait))
+	}
+	return c
+}
+
+// setReadRemaining is the single place that mutates readRemaining. Routing
+// every initial-length decode, mask-consumption decrement and continuation
+// update through here means an attacker-controlled frame length can never
+// drive readRemaining negative.
+func (c *Conn) setReadRemaining(n int64) error {
+	if n < 0 {
+		return ErrReadLimit
+	}
+	c.readRemaining = n
+	return nil
+}
+
+// LocalAddr returns the local network address.
+func (c *Conn) LocalAddr() net.Addr { return c.conn.LocalAddr() }
+
+// RemoteAddr returns the remote network address.
+func (c *Conn) RemoteAddr() net.Addr { return c.conn.RemoteAddr() }
+
+// Subprotocol returns the negotiated protocol for the connection.
+func (c *Conn) Subprotocol() string { return c.subprotocol }
+
+// Close closes the underlying network connection without sending or
+// waiting for a close message. If EnableWriteQueue was called, it also
+// stops the write-queue goroutine, without waiting for messages still
+// queued by WriteMessageAsync to reach the wire; call Flush first if
+// those messages must be delivered before closing.
+func (c *Conn) Close() error {
+	if c.writeQueue != nil {
+		c.writeQueueMu.Lock()
+		if !c.writeQueueClosed {
+			c.writeQueueClosed = true
+			close(c.writeQueueDone)
+		}
+		c.writeQueueMu.Unlock()
+	}
+	return c.conn.Close()
+}
+
+// SetReadLimit sets the maximum size in bytes for a message read from the
+// peer. If a message exceeds the limit, the connection sends a close
+// message to the peer and Read returns ErrReadLimit to the application.
+func (c *Conn) SetReadLimit(limit int64) { c.readLimit = limit }
+
+// EnableWriteCompression enables or disables permessage-deflate
+// compression of subsequent outgoing messages. It has no effect unless
+// the permessage-deflate extension was negotiated during the handshake.
+func (c *Conn) EnableWriteCompression(enable bool) { c.enableWriteCompression = enable }
+
+// SetCompressionLevel sets the compress/flate level used when writing
+// compressed messages. See the compress/flate package for the valid
+// range; it returns an error if level is out of range.
+func (c *Conn) SetCompressionLevel(level int) error {
+	if level < flate.HuffmanOnly || level > flate.BestCompression {
+		return errors.New("websocket: invalid compression level")
+	}
+	c.compressionLevel = level
+	return nil
+}
+
+// SetReadDeadline sets the read deadline on the underlying network
+// connection.
+func (c *Conn) SetReadDeadline(t time.Time) error { return c.conn.SetReadDeadline(t) }
+
+// SetWriteDeadline sets the write deadline on the underlying network
+// connection.
+func (c *Conn) SetWriteDeadline(t time.Time) error { return c.conn.SetWriteDeadline(t) }
+
+// SetPingHandler sets the handler for ping messages received from the peer.
+// The default handler sends a pong back to the peer.
+func (c *Conn) SetPingHandler(h func(string) error) {
+	if h == nil {
+		h = func(string) error { return nil }
+	}
+	c.handlePing = h
+}
+
+// SetPongHandler sets the handler for pong messages received from the peer.
+// The default handler does nothing.
+func (c *Conn) SetPongHandler(h func(string) error) {
+	if h == nil {
+		h = func(string) error { return nil }
+	}
+	c.handlePong = h
+}
+
+// SetCloseHandler sets the handler for close messages received from the
+// peer. The default handler echoes the close code back to the peer.
+func (c *Conn) SetCloseHandler(h func(int, string) error) {
+	if h == nil {
+		h = func(code int, text string) error {
+			return c.WriteControl(OpClose, FormatCloseMessage(code, ""), time.Now().Add(writeWait))
+		}
+	}
+	c.handleClose = h
+}
+
+// FormatCloseMessage formats closeCode and text as a WebSocket close
+// message.
+func FormatCloseMessage(closeCode int, text string) []byte {
+	buf := make([]byte, 2+len(text))
+	binary.BigEndian.PutUint16(buf, uint16(closeCode))
+	copy(buf[2:], text)
+	return buf
+}
+
+// writeFatal records err as the reason this connection can no longer write
+// and returns it for convenience.
+func (c *Conn) writeFatal(err error) error {
+	c.writeErr = err
+	return err
+}
+
+// writeFrame assembles a single frame of the given type and payload and
+// writes it to the network. It holds writeMu for the duration of the
+// physical write, which is what lets WriteControl jump in front of (or
+// behind) a data frame being flushed by a messageWriter without tearing
+// either one.
+func (c *Conn) writeFrame(frameType int, final, rsv1 bool, data []byte) error {
+	if c.writeErr != nil {
+		return c.writeErr
+	}
+
+	length := len(data)
+	b0 := byte(frameType)
+	if final {
+		b0 |= finBit
+	}
+	if rsv1 {
+		b0 |= rsv1Bit
+	}
+
+	var header []byte
+	switch {
+	case length >= 65536:
+		header = make([]byte, 2+8)
+		header[1] = 127
+		binary.BigEndian.PutUint64(header[2:], uint64(length))
+	case length > 125:
+		header = make([]byte, 2+2)
+		header[1] = 126
+		binary.BigEndian.PutUint16(header[2:], uint16(length))
+	default:
+		header = make([]byte, 2)
+		header[1] = byte(length)
+	}
+	header[0] = b0
+
+	var key [4]byte
+	if !c.isServer {
+		header[1] |= maskBit
+		key = newMaskKey()
+		maskBytes(key, 0, data)
+	}
+
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+
+	if _, err := c.conn.Write(header); err != nil {
+		return c.writeFatal(err)
+	}
+	if !c.isServer {
+		if _, err := c.conn.Write(key[:]); err != nil {
+			return c.writeFatal(err)
+		}
+	}
+	if len(data) > 0 {
+		if _, err := c.conn.Write(data); err != nil {
+			return c.writeFatal(err)
+		}
+	}
+	if final && frameType == OpClose {
+		c.closeSent = true
+	}
+	return nil
+}
+
+// WriteControl writes a control message with the given deadline. The
+// allowed message types are OpClose, OpPing and OpPong. Unlike data
+// messages written through NextWriter, WriteControl does not wait for an
+// open writer to close, so ping/pong/close traffic is never blocked behind
+// an in-progress fragmented message.
+func (c *Conn) WriteControl(messageType int, data []byte, deadline time.Time) error {
+	if !isControl(messageType) {
+		return ErrBadWriteOpCode
+	}
+	if len(data) > maxControlFramePayloadSize {
+		return errors.New("websocket: control frame too large")
+	}
+	c.conn.SetWriteDeadline(deadline)
+	// writeFrame masks data in place; control payloads are always
+	// freshly allocated by the caller (e.g. FormatCloseMessage), so this
+	// is safe.
+	return c.writeFrame(messageType, true, false, data)
+}
+
+// WriteMessage writes a message with the given type and payload as a
+// single frame.
+func (c *Conn) WriteMessage(messageType int, data []byte) error {
+	w, err := c.NextWriter(messageType)
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(data); err != nil {
+		return err
+	}
+	return w.Close()
+}
+
+// NextWriter returns a writer for the next message to send. Data is
+// buffered up to the connection's write buffer size and flushed as a
+// (non-final) frame whenever the buffer fills; the writer's Close method
+// flushes whatever remains as the final frame of the message. Because each
+// full buffer is flushed to the network as soon as it fills, a fragmented
+// message never blocks a concurrently written control frame (see
+// WriteControl) behind it for longer than a single buffer's worth of data.
+//
+// There can be at most one open writer on a connection at a time.
+func (c *Conn) NextWriter(messageType int) (io.WriteCloser, error) {
+	if !isData(messageType) {
+		return nil, ErrBadWriteOpCode
+	}
+
+	select {
+	case <-c.mu:
+	default:
+		return nil, errors.New("websocket: concurrent write to websocket connection")
+	}
+
+	if c.writeErr != nil {
+		c.mu <- struct{}{}
+		return nil, c.writeErr
+	}
+	if c.closeSent {
+		c.mu <- struct{}{}
+		return nil, ErrCloseSent
+	}
+
+	return &messageWriter{
+		c:         c,
+		frameType: messageType,
+		buf:       make([]byte, 0, c.writeBufSize),
+		compress:  c.permessageDeflate && c.enableWriteCompression,
+	}, nil
+}
+
+// CompressionWriter is implemented by the io.WriteCloser returned from
+// NextWriter. Use it to opt a single message out of permessage-deflate
+// compression even though compression is otherwise enabled for the
+// connection.
+type CompressionWriter interface {
+	io.WriteCloser
+	DisableCompression()
+}
+
+type messageWriter struct {
+	c         *Conn
+	frameType int
+	buf       []byte
+	err       error
+	compress  bool
+	cw        *compressWriter
+}
+
+// DisableCompression opts this message out of permessage-deflate
+// compression. It has no effect once Write has already been called.
+func (w *messageWriter) DisableCompression() {
+	if w.cw == nil {
+		w.compress = false
+	}
+}
+
+func (w *messageWriter) flush(final bool) error {
+	rsv1 := w.compress && w.frameType != OpContinuation
+	err := w.c.writeFrame(w.frameType, final, rsv1, w.buf)
+	w.frameType = OpContinuation
+	w.buf = w.buf[:0]
+	return err
+}
+
+// writeRaw appends p to the pending frame buffer, flushing a
+// (non-final) frame to the network whenever it fills.
+func (w *messageWriter) writeRaw(p []byte) (int, error) {
+	nn := len(p)
+	for len(p) > 0 {
+		n := cap(w.buf) - len(w.buf)
+		if n == 0 {
+			if err := w.flush(false); err != nil {
+				return 0, err
+			}
+			n = cap(w.buf)
+		}
+		if n > len(p) {
+			n = len(p)
+		}
+		w.buf = append(w.buf, p[:n]...)
+		p = p[n:]
+	}
+	return nn, nil
+}
+
+func (w *messageWriter) Write(p []byte) (int, error) {
+	if w.err != nil {
+		return 0, w.err
+	}
+	if w.compress {
+		if w.cw == nil {
+			cw, err := newCompressionWriter(writerFunc(w.writeRaw), w.c.compressionLevel)
+			if err != nil {
+				return 0, err
+			}
+			w.cw = cw
+		}
+		return w.cw.Write(p)
+	}
+	return w.writeRaw(p)
+}
+
+func (w *messageWriter) Close() error {
+	if w.err != nil {
+		return w.err
+	}
+	if w.cw != nil {
+		if err := w.cw.Close(); err != nil {
+			w.err = err
+			w.c.mu <- struct{}{}
+			return err
+		}
+	}
+	err := w.flush(true)
+	w.err = errors.New("websocket: write to closed writer")
+	w.c.mu <- struct{}{}
+	return err
+}
+
+// NextReader returns the type and a reader for the next message received
+// from the peer, which may be a data message (OpText, OpBinary) or a
+// control message (OpPing, OpPong, OpClose). Continuation frames left
+// unread by the caller from a prior message are skipped transparently;
+// control frames encountered along the way are surfaced to the caller
+// instead of being skipped, so ping/pong/close traffic interleaved with a
+// fragmented message is never silently dropped.
+func (c *Conn) NextReader() (messageType int, r io.Reader, err error) {
+	for {
+		frameType, err := c.advanceFrame()
+		if err != nil {
+			return frameType, nil, err
+		}
+		if frameType != OpContinuation {
+			var r io.Reader = messageReader{c}
+			if frameType != OpClose && c.readDecompress {
+				r = newDecompressionReader(r)
+			}
+			if frameType == OpText {
+				r = newUTF8Reader(c, r)
+			}
+			return frameType, r, nil
+		}
+	}
+}
+
+// advanceFrame reads and validates the header of the next frame on the
+// wire, consuming and dispatching an entire payload for control frames.
+func (c *Conn) advanceFrame() (int, error) {
+	// Skip whatever is left of the frame the caller didn't finish reading.
+	if c.readRemaining > 0 {
+		if _, err := io.CopyN(ioutil.Discard, c, c.readRemaining); err != nil {
+			return OpClose, err
+		}
+	}
+
+	p, err := c.read(2)
+	if err != nil {
+		return OpClose, err
+	}
+
+	final := p[0]&finBit != 0
+	frameType := int(p[0] & 0xf)
+	rsv1 := p[0]&rsv1Bit != 0
+	mask := p[1]&maskBit != 0
+	if p[0]&(rsv2Bit|rsv3Bit) != 0 {
+		// Section 5.2 of RFC 6455: RSV2 and RSV3 are reserved for
+		// extensions; this package negotiates none that use them, so a
+		// peer setting either one is a protocol violation.
+		return OpClose, errors.New("websocket: RSV2 or RSV3 set without a negotiated extension")
+	}
+	if err := c.setReadRemaining(int64(p[1] & 0x7f)); err != nil {
+		return OpClose, err
+	}
+
+	switch c.readRemaining {
+	case 126:
+		p, err := c.read(2)
+		if err != nil {
+			return OpClose, err
+		}
+		if err := c.setReadRemaining(int64(binary.BigEndian.Uint16(p))); err != nil {
+			return OpClose, err
+		}
+	case 127:
+		p, err := c.read(8)
+		if err != nil {
+			return OpClose, err
+		}
+		// Decode as unsigned first: a peer that sets the high bit of the
+		// 64-bit extended length must be rejected outright rather than
+		// have the value silently become negative (or wrap to a small
+		// number) when narrowed to int64.
+		n64 := binary.BigEndian.Uint64(p)
+		if n64 > math.MaxInt64 {
+			return OpClose, ErrReadLimit
+		}
+		if err := c.setReadRemaining(int64(n64)); err != nil {
+			return OpClose, err
+		}
+	}
+
+	c.readMasked = mask
+	if mask {
+		c.readMaskPos = 0
+		p, err := c.read(4)
+		if err != nil {
+			return OpClose, err
+		}
+		copy(c.readMaskKey[:], p)
+	}
+
+	if isControl(frameType) {
+		if rsv1 {
+			return OpClose, errors.New("websocket: RSV1 set on a control frame")
+		}
+		if c.readRemaining > maxControlFramePayloadSize {
+			return OpClose, errors.New("websocket: control frame too large")
+		}
+		if !final {
+			return OpClose, errors.New("websocket: control frame not final")
+		}
+		var payload []byte
+		if c.readRemaining > 0 {
+			payload, err = c.read(int(c.readRemaining))
+			if err != nil {
+				return OpClose, err
+			}
+			if mask {
+				maskBytes(c.readMaskKey, 0, payload)
+			}
+			if err := c.setReadRemaining(0); err != nil {
+				return OpClose, err
+			}
+		}
+
+		switch frameType {
+		case OpPong:
+			c.handlePong(string(payload))
+		case OpPing:
+			c.handlePing(string(payload))
+		case OpClose:
+			closeCode := CloseNoStatusReceived
+			closeText := ""
+			switch {
+			case len(payload) == 1:
+				// Section 5.5.1 of RFC 6455: a close code, if present, is
+				// always 2 bytes; a lone byte is a protocol error.
+				c.handleClose(CloseProtocolError, "")
+				return OpClose, &CloseError{Code: CloseProtocolError, Text: "websocket: invalid close frame payload length"}
+			case len(payload) >= 2:
+				closeCode = int(binary.BigEndian.Uint16(payload))
+				closeText = string(payload[2:])
+				if !validCloseCode(closeCode) {
+					c.handleClose(CloseProtocolError, "")
+					return OpClose, &CloseError{Code: CloseProtocolError, Text: "websocket: invalid close code " + strconv.Itoa(closeCode)}
+				}
+				if !utf8.ValidString(closeText) {
+					c.handleClose(CloseProtocolError, "")
+					return OpClose, &CloseError{Code: CloseProtocolError, Text: "websocket: invalid utf-8 in close reason"}
+				}
+			}
+			c.handleClose(closeCode, closeText)
+			return OpClose, &CloseError{Code: closeCode, Text: closeText}
+		}
+		return frameType, nil
+	}
+
+	if frameType == OpContinuation {
+		if c.readFinal {
+			return OpClose, errors.New("websocket: continuation frame without data frame")
+		}
+		if rsv1 {
+			return OpClose, errors.New("websocket: RSV1 set on continuation frame")
+		}
+	} else {
+		if !c.readFinal {
+			return OpClose, errors.New("websocket: data frame seen while continuation expected")
+		}
+		if rsv1 && !c.permessageDeflate {
+			return OpClose, errors.New("websocket: RSV1 set without a negotiated permessage-deflate extension")
+		}
+		c.readLength = 0
+		c.readDecompress = rsv1
+	}
+	c.readFinal = final
+
+	if mask != c.isServer {
+		// A server must receive masked frames; a client must receive
+		// unmasked frames.
+		return OpClose, errors.New("websocket: incorrect mask flag")
+	}
+
+	return frameType, nil
+}
+
+// read reads exactly n bytes from the underlying buffered reader.
+func (c *Conn) read(n int) ([]byte, error) {
+	p := make([]byte, n)
+	if _, err := io.ReadFull(c.br, p); err != nil {
+		c.readErr = err
+		return nil, err
+	}
+	return p, nil
+}
+
+// Read implements io.Reader over the body of the current frame. It is used
+// directly by advanceFrame to skip unread data and by messageReader to
+// expose the body of the current message to the application.
+func (c *Conn) Read(b []byte) (int, error) {
+	if c.readErr != nil {
+		return 0, c.readErr
+	}
+	if c.readRemaining <= 0 {
+		return 0, io.EOF
+	}
+	if int64(len(b)) > c.readRemaining {
+		b = b[:c.readRemaining]
+	}
+	n, err := c.br.Read(b)
+	if err != nil {
+		if err == io.EOF && c.readRemaining > int64(n) {
+			err = io.ErrUnexpectedEOF
+		}
+		c.readErr = err
+		return n, err
+	}
+	if err := c.setReadRemaining(c.readRemaining - int64(n)); err != nil {
+		return n, err
+	}
+	if c.readMasked {
+		c.readMaskPos = maskBytes(c.readMaskKey, c.readMaskPos, b[:n])
+	}
+	c.readLength += int64(n)
+	if c.readLimit > 0 && c.readLength > c.readLimit {
+		c.WriteControl(OpClose, FormatCloseMessage(CloseMessageTooBig, ""), time.Now().Add(writeWait))
+		c.readErr = ErrReadLimit
+		return n, ErrReadLimit
+	}
+	return n, nil
+}
+
+// messageReader exposes the body of the current data message, spanning
+// fragmented continuation frames, as an io.Reader.
+type messageReader struct{ c *Conn }
+
+func (r messageReader) Read(b []byte) (int, error) {
+	c := r.c
+	for c.readRemaining == 0 {
+		if c.readFinal {
+			return 0, io.EOF
+		}
+		frameType, err := c.advanceFrame()
+		if err != nil {
+			return 0, err
+		}
+		// advanceFrame fully consumes and dispatches control frames before
+		// returning them; only OpContinuation carries more of this message,
+		// so a ping or pong interleaved mid-fragment is simply skipped.
+		if frameType != OpContinuation && frameType != OpPing && frameType != OpPong {
+			return 0, errors.New("websocket: internal error, unexpected frame type")
+		}
+	}
+	return c.Read(b)
+}
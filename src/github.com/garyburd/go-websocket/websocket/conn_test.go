@@ -48,9 +48,25 @@ func TestFraming(t *testing.T) {
 		{"asis", func(r io.Reader) io.Reader { return r }},
 	}
 
-	writeBuf := make([]byte, 65537)
-	for i := range writeBuf {
-		writeBuf[i] = byte(i)
+	// binaryBuf exercises every byte value, which is fine for OpBinary but
+	// would trip the NextReader UTF-8 check if sent as OpText; textBuf
+	// covers the same frame-size matrix with valid UTF-8 (every byte a
+	// 1-byte code point) so OpText gets the same exhaustive coverage.
+	binaryBuf := make([]byte, 65537)
+	for i := range binaryBuf {
+		binaryBuf[i] = byte(i)
+	}
+	textBuf := make([]byte, 65537)
+	for i := range textBuf {
+		textBuf[i] = byte(i % 128)
+	}
+
+	opCodes := []struct {
+		opCode   int
+		writeBuf []byte
+	}{
+		{OpBinary, binaryBuf},
+		{OpText, textBuf},
 	}
 
 	for _, isServer := range []bool{true, false} {
@@ -60,53 +76,55 @@ func TestFraming(t *testing.T) {
 			wc := newConn(fakeNetConn{Reader: nil, Writer: &connBuf}, isServer, 1024, 1024)
 			rc := newConn(fakeNetConn{Reader: chunker.f(&connBuf), Writer: nil}, !isServer, 1024, 1024)
 
-			for _, n := range frameSizes {
-				for _, iocopy := range []bool{true, false} {
-					name := fmt.Sprintf("s:%b, r:%s, n:%d c:%s", isServer, chunker.name, n, iocopy)
+			for _, oc := range opCodes {
+				for _, n := range frameSizes {
+					for _, iocopy := range []bool{true, false} {
+						name := fmt.Sprintf("s:%v, r:%s, op:%d, n:%d c:%v", isServer, chunker.name, oc.opCode, n, iocopy)
 
-					w, err := wc.NextWriter(OpText)
-					if err != nil {
-						t.Errorf("%s: wc.NextWriter() returned %v", name, err)
-						continue
-					}
-					var nn int
-					if iocopy {
-						var n64 int64
-						n64, err = io.Copy(w, bytes.NewReader(writeBuf[:n]))
-						nn = int(n64)
-					} else {
-						nn, err = w.Write(writeBuf[:n])
-					}
-					if err != nil || nn != n {
-						t.Errorf("%s: w.Write(writeBuf[:n]) returned %d, %v", name, nn, err)
-						continue
-					}
-					err = w.Close()
-					if err != nil {
-						t.Errorf("%s: w.Close() returned %v", name, err)
-						continue
-					}
+						w, err := wc.NextWriter(oc.opCode)
+						if err != nil {
+							t.Errorf("%s: wc.NextWriter() returned %v", name, err)
+							continue
+						}
+						var nn int
+						if iocopy {
+							var n64 int64
+							n64, err = io.Copy(w, bytes.NewReader(oc.writeBuf[:n]))
+							nn = int(n64)
+						} else {
+							nn, err = w.Write(oc.writeBuf[:n])
+						}
+						if err != nil || nn != n {
+							t.Errorf("%s: w.Write(writeBuf[:n]) returned %d, %v", name, nn, err)
+							continue
+						}
+						err = w.Close()
+						if err != nil {
+							t.Errorf("%s: w.Close() returned %v", name, err)
+							continue
+						}
 
-					opCode, r, err := rc.NextReader()
-					if err != nil || opCode != OpText {
-						t.Errorf("%s: NextReader() returned %d, r, %v", name, opCode, err)
-						continue
-					}
-					rbuf, err := ioutil.ReadAll(r)
-					if err != nil {
-						t.Errorf("%s: ReadFull() returned rbuf, %v", name, err)
-						continue
-					}
+						opCode, r, err := rc.NextReader()
+						if err != nil || opCode != oc.opCode {
+							t.Errorf("%s: NextReader() returned %d, r, %v", name, opCode, err)
+							continue
+						}
+						rbuf, err := ioutil.ReadAll(r)
+						if err != nil {
+							t.Errorf("%s: ReadFull() returned rbuf, %v", name, err)
+							continue
+						}
 
-					if len(rbuf) != n {
-						t.Errorf("%s: len(rbuf) is %d, want %d", name, len(rbuf), n)
-						continue
-					}
+						if len(rbuf) != n {
+							t.Errorf("%s: len(rbuf) is %d, want %d", name, len(rbuf), n)
+							continue
+						}
 
-					for i, b := range rbuf {
-						if byte(i) != b {
-							t.Errorf("%s: bad byte at offset %d", name, i)
-							break
+						for i, b := range rbuf {
+							if oc.writeBuf[i] != b {
+								t.Errorf("%s: bad byte at offset %d", name, i)
+								break
+							}
 						}
 					}
 				}
@@ -115,6 +133,84 @@ func TestFraming(t *testing.T) {
 	}
 }
 
+// TestFragmentedMessageWithInterleavedControlFrame verifies that a ping
+// arriving between the continuation frames of a still-open fragmented
+// message is consumed transparently by messageReader.Read, rather than
+// aborting the read with an internal error and truncating the message.
+func TestFragmentedMessageWithInterleavedControlFrame(t *testing.T) {
+	var b1, b2 bytes.Buffer
+	wc := newConn(fakeNetConn{Reader: nil, Writer: &b1}, false, 1024, 1024)
+	rc := newConn(fakeNetConn{Reader: &b1, Writer: &b2}, true, 1024, 1024)
+
+	if err := wc.writeFrame(OpBinary, false, false, []byte("abcde")); err != nil {
+		t.Fatalf("writeFrame(OpBinary) returned %v", err)
+	}
+	if err := wc.WriteControl(OpPing, []byte("ping"), time.Now().Add(10*time.Second)); err != nil {
+		t.Fatalf("WriteControl() returned %v", err)
+	}
+	if err := wc.writeFrame(OpContinuation, true, false, []byte("fghij")); err != nil {
+		t.Fatalf("writeFrame(OpContinuation) returned %v", err)
+	}
+
+	op, r, err := rc.NextReader()
+	if op != OpBinary || err != nil {
+		t.Fatalf("NextReader() returned %d, %v", op, err)
+	}
+	got, err := ioutil.ReadAll(r)
+	if err != nil {
+		t.Fatalf("ReadAll() returned %v", err)
+	}
+	if string(got) != "abcdefghij" {
+		t.Fatalf("ReadAll() returned %q, want %q", got, "abcdefghij")
+	}
+}
+
+// TestReservedBitsRejected checks that a peer setting a reserved header
+// bit this package never negotiates an extension for - RSV1 without
+// permessage-deflate, or either of RSV2/RSV3 at all - fails the
+// connection (Section 5.2 of RFC 6455) instead of being accepted.
+func TestReservedBitsRejected(t *testing.T) {
+	t.Run("RSV1 without negotiated compression", func(t *testing.T) {
+		var buf bytes.Buffer
+		wc := newConn(fakeNetConn{Reader: nil, Writer: &buf}, false, 1024, 1024)
+		if err := wc.writeFrame(OpBinary, true, true, []byte("hi")); err != nil {
+			t.Fatalf("writeFrame() returned %v", err)
+		}
+		rc := newConn(fakeNetConn{Reader: &buf, Writer: nil}, true, 1024, 1024)
+		if _, _, err := rc.NextReader(); err == nil {
+			t.Fatal("NextReader() returned nil error, want a protocol error for unsolicited RSV1")
+		}
+	})
+
+	t.Run("RSV2 set", func(t *testing.T) {
+		var buf bytes.Buffer
+		wc := newConn(fakeNetConn{Reader: nil, Writer: &buf}, false, 1024, 1024)
+		if err := wc.writeFrame(OpBinary, true, false, []byte("hi")); err != nil {
+			t.Fatalf("writeFrame() returned %v", err)
+		}
+		raw := buf.Bytes()
+		raw[0] |= rsv2Bit
+		rc := newConn(fakeNetConn{Reader: bytes.NewReader(raw), Writer: nil}, true, 1024, 1024)
+		if _, _, err := rc.NextReader(); err == nil {
+			t.Fatal("NextReader() returned nil error, want a protocol error for RSV2")
+		}
+	})
+
+	t.Run("RSV3 set", func(t *testing.T) {
+		var buf bytes.Buffer
+		wc := newConn(fakeNetConn{Reader: nil, Writer: &buf}, false, 1024, 1024)
+		if err := wc.writeFrame(OpBinary, true, false, []byte("hi")); err != nil {
+			t.Fatalf("writeFrame() returned %v", err)
+		}
+		raw := buf.Bytes()
+		raw[0] |= rsv3Bit
+		rc := newConn(fakeNetConn{Reader: bytes.NewReader(raw), Writer: nil}, true, 1024, 1024)
+		if _, _, err := rc.NextReader(); err == nil {
+			t.Fatal("NextReader() returned nil error, want a protocol error for RSV3")
+		}
+	})
+}
+
 func TestReadLimit(t *testing.T) {
 
 	const readLimit = 512
@@ -151,4 +247,19 @@ func TestReadLimit(t *testing.T) {
 	if err != ErrReadLimit {
 		t.Fatalf("io.Copy() returned %v", err)
 	}
+
+	t.Run("overflow", func(t *testing.T) {
+		// A 127-length header whose extended 64-bit length has the high
+		// bit set must be rejected outright instead of being narrowed
+		// into a negative (or small, wrapped) int64 readRemaining.
+		frame := []byte{
+			finBit | OpBinary, 127,
+			0x80, 0, 0, 0, 0, 0, 0, 0, // 1<<63, too large for int64
+		}
+		conn := newConn(fakeNetConn{Reader: bytes.NewReader(frame), Writer: ioutil.Discard}, true, 1024, 1024)
+		_, _, err := conn.NextReader()
+		if err != ErrReadLimit {
+			t.Fatalf("NextReader() returned %v, want ErrReadLimit", err)
+		}
+	})
 }
@@ -0,0 +1,87 @@
+// Copyright 2013 Gary Burd
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package websocket
+
+import "strings"
+
+// extension is one comma-separated offer or acceptance from a
+// Sec-WebSocket-Extensions header: a name plus its semicolon-separated
+// parameters. A parameter with no "=value" (e.g. client_no_context_takeover)
+// is recorded with an empty value.
+type extension struct {
+	name   string
+	params map[string]string
+}
+
+// parseExtensionHeader parses the (possibly multi-valued, comma-joined)
+// Sec-WebSocket-Extensions header into its individual extension offers.
+func parseExtensionHeader(header string) []extension {
+	var result []extension
+	for _, part := range strings.Split(header, ",") {
+		tokens := strings.Split(part, ";")
+		name := strings.ToLower(strings.TrimSpace(tokens[0]))
+		if name == "" {
+			continue
+		}
+		ext := extension{name: name, params: make(map[string]string)}
+		for _, tok := range tokens[1:] {
+			tok = strings.TrimSpace(tok)
+			if tok == "" {
+				continue
+			}
+			if i := strings.IndexByte(tok, '='); i >= 0 {
+				key := strings.ToLower(strings.TrimSpace(tok[:i]))
+				val := strings.Trim(strings.TrimSpace(tok[i+1:]), `"`)
+				ext.params[key] = val
+			} else {
+				ext.params[strings.ToLower(tok)] = ""
+			}
+		}
+		result = append(result, ext)
+	}
+	return result
+}
+
+// permessageDeflateOffer returns the client's permessage-deflate offer
+// from a parsed Sec-WebSocket-Extensions header, if any.
+func permessageDeflateOffer(extensions []extension) (extension, bool) {
+	for _, ext := range extensions {
+		if ext.name == "permessage-deflate" {
+			return ext, true
+		}
+	}
+	return extension{}, false
+}
+
+// acceptPermessageDeflate builds the response extension the server sends
+// back once it accepts a client's permessage-deflate offer. This
+// implementation always compresses each message independently (it never
+// keeps a sliding window across messages), so the response always
+// advertises both *_no_context_takeover parameters regardless of what the
+// client asked for; max_window_bits parameters are accepted and echoed
+// back but otherwise unused, since compress/flate does not expose a way to
+// shrink the LZ77 window.
+func acceptPermessageDeflate(offer extension) string {
+	resp := "permessage-deflate; client_no_context_takeover; server_no_context_takeover"
+	if v, ok := offer.params["client_max_window_bits"]; ok && v != "" {
+		resp += "; client_max_window_bits=" + v
+	}
+	if v, ok := offer.params["server_max_window_bits"]; ok && v != "" {
+		resp += "; server_max_window_bits=" + v
+	}
+	return resp
+}
+
+const permessageDeflateOfferHeader = "permessage-deflate; client_no_context_takeover; server_no_context_takeover"
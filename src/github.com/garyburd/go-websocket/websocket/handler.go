@@ -0,0 +1,152 @@
+// Copyright 2013 Gary Burd
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package websocket
+
+import (
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+)
+
+// Handler adapts an Upgrader into an http.Handler that runs a connection's
+// whole message loop, demultiplexing messages by WebSocket frame type:
+// text messages are JSON-decoded and delivered to OnJSON, binary messages
+// are delivered to OnBinary as raw bytes. This is the extension point a
+// server that needs to speak a structured control protocol and carry an
+// opaque tunneled byte stream over the same browser-facing connection —
+// such as an ngrok-style tunnel's web console — plugs into; this package
+// does not itself contain such a server.
+type Handler struct {
+	// Upgrader performs the HTTP-to-WebSocket handshake.
+	Upgrader Upgrader
+
+	// OnConnect is called once the connection is established, before any
+	// messages are dispatched. If it returns an error, the connection is
+	// closed immediately and no messages are dispatched.
+	OnConnect func(c *Conn) error
+
+	// New, if set, is called once per text message to obtain the value
+	// OnJSON should decode that message into (typically a pointer to a
+	// protocol-specific struct). If nil, OnJSON receives a
+	// *map[string]interface{}.
+	New func() interface{}
+
+	// OnJSON is called for each text message with v populated by decoding
+	// the message as JSON into the value New returned (or the default
+	// map, if New is nil).
+	OnJSON func(c *Conn, v interface{})
+
+	// OnBinary is called for each binary message with its raw payload.
+	OnBinary func(c *Conn, data []byte)
+
+	// OnClose is called once the connection's message loop ends, with the
+	// error that ended it: io.EOF or a *CloseError on a normal close, or
+	// the error returned by OnConnect if that is what ended it.
+	OnClose func(c *Conn, err error)
+
+	// ErrorLog receives per-message errors that do not end the
+	// connection, such as a text message that fails to decode as JSON.
+	// If nil, log.Printf is used.
+	ErrorLog func(format string, args ...interface{})
+}
+
+func (h *Handler) logf(format string, args ...interface{}) {
+	if h.ErrorLog != nil {
+		h.ErrorLog(format, args...)
+		return
+	}
+	log.Printf(format, args...)
+}
+
+// ServeHTTP upgrades the request to a WebSocket connection and runs its
+// message loop, blocking until the connection closes. If the upgrade
+// itself fails, Upgrader.Upgrade has already written an HTTP error
+// response and ServeHTTP returns without calling OnConnect or OnClose.
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	c, err := h.Upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		return
+	}
+	h.Serve(c)
+}
+
+// Serve runs the message loop for an already-upgraded connection,
+// dispatching messages to OnJSON/OnBinary until the connection closes,
+// then calls OnClose with the error that ended the loop. It blocks the
+// calling goroutine for the lifetime of the connection and closes c
+// before returning.
+func (h *Handler) Serve(c *Conn) {
+	var err error
+	defer func() {
+		c.Close()
+		if h.OnClose != nil {
+			h.OnClose(c, err)
+		}
+	}()
+
+	if h.OnConnect != nil {
+		if err = h.OnConnect(c); err != nil {
+			return
+		}
+	}
+
+	for {
+		var messageType int
+		var r io.Reader
+		messageType, r, err = c.NextReader()
+		if err != nil {
+			return
+		}
+		switch messageType {
+		case OpText:
+			h.dispatchJSON(c, r)
+		case OpBinary:
+			h.dispatchBinary(c, r)
+		}
+	}
+}
+
+func (h *Handler) dispatchJSON(c *Conn, r io.Reader) {
+	if h.OnJSON == nil {
+		io.Copy(ioutil.Discard, r)
+		return
+	}
+	var v interface{}
+	if h.New != nil {
+		v = h.New()
+	} else {
+		v = &map[string]interface{}{}
+	}
+	if err := json.NewDecoder(r).Decode(v); err != nil {
+		h.logf("websocket: handler: invalid JSON message: %v", err)
+		return
+	}
+	h.OnJSON(c, v)
+}
+
+func (h *Handler) dispatchBinary(c *Conn, r io.Reader) {
+	if h.OnBinary == nil {
+		io.Copy(ioutil.Discard, r)
+		return
+	}
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		h.logf("websocket: handler: reading binary message: %v", err)
+		return
+	}
+	h.OnBinary(c, data)
+}
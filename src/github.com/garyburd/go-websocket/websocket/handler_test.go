@@ -0,0 +1,78 @@
+// Copyright 2013 Gary Burd
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package websocket
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+type handlerTestMessage struct {
+	Kind string `json:"kind"`
+}
+
+func TestHandlerDispatchesByFrameType(t *testing.T) {
+	var gotJSON []handlerTestMessage
+	var gotBinary [][]byte
+	closed := make(chan error, 1)
+
+	h := &Handler{
+		New: func() interface{} { return &handlerTestMessage{} },
+		OnJSON: func(c *Conn, v interface{}) {
+			gotJSON = append(gotJSON, *v.(*handlerTestMessage))
+		},
+		OnBinary: func(c *Conn, data []byte) {
+			gotBinary = append(gotBinary, append([]byte(nil), data...))
+		},
+		OnClose: func(c *Conn, err error) {
+			closed <- err
+		},
+	}
+
+	srv := httptest.NewServer(http.HandlerFunc(h.ServeHTTP))
+	defer srv.Close()
+
+	d := &Dialer{}
+	wsURL := "ws" + strings.TrimPrefix(srv.URL, "http")
+	c, _, err := d.Dial(wsURL, nil)
+	if err != nil {
+		t.Fatalf("Dial() returned %v", err)
+	}
+
+	if err := c.WriteJSON(handlerTestMessage{Kind: "control"}); err != nil {
+		t.Fatalf("WriteJSON() returned %v", err)
+	}
+	if err := c.WriteMessage(OpBinary, []byte("tunnel payload")); err != nil {
+		t.Fatalf("WriteMessage() returned %v", err)
+	}
+	c.Close()
+
+	select {
+	case <-closed:
+	case <-time.After(2 * time.Second):
+		t.Fatal("timed out waiting for OnClose")
+	}
+
+	if len(gotJSON) != 1 || gotJSON[0].Kind != "control" {
+		t.Fatalf("gotJSON = %+v, want one message with Kind \"control\"", gotJSON)
+	}
+	if len(gotBinary) != 1 || !bytes.Equal(gotBinary[0], []byte("tunnel payload")) {
+		t.Fatalf("gotBinary = %q, want one message %q", gotBinary, "tunnel payload")
+	}
+}
@@ -0,0 +1,49 @@
+// Copyright 2013 Gary Burd
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package websocket
+
+import (
+	"encoding/json"
+	"errors"
+)
+
+// WriteJSON writes the JSON encoding of v as a text message.
+func (c *Conn) WriteJSON(v interface{}) error {
+	w, err := c.NextWriter(OpText)
+	if err != nil {
+		return err
+	}
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		w.Close()
+		return err
+	}
+	return w.Close()
+}
+
+// ReadJSON reads the next text message and decodes it as JSON into v.
+func (c *Conn) ReadJSON(v interface{}) error {
+	messageType, r, err := c.NextReader()
+	if err != nil {
+		return err
+	}
+	if messageType != OpText {
+		return ErrNotTextMessage
+	}
+	return json.NewDecoder(r).Decode(v)
+}
+
+// ErrNotTextMessage is returned by ReadJSON when the next message on the
+// connection is not a text message.
+var ErrNotTextMessage = errors.New("websocket: expected a text message carrying JSON")
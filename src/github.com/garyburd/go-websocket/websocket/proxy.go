@@ -0,0 +1,300 @@
+// Copyright 2013 Gary Burd
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package websocket
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+)
+
+// dialNet opens a TCP connection to addr, using NetDialContext if set,
+// falling back to NetDial, and finally to net.Dialer.DialContext.
+func (d *Dialer) dialNet(ctx context.Context, network, addr string) (net.Conn, error) {
+	if d.NetDialContext != nil {
+		return d.NetDialContext(ctx, network, addr)
+	}
+	if d.NetDial != nil {
+		return d.NetDial(network, addr)
+	}
+	var zd net.Dialer
+	return zd.DialContext(ctx, network, addr)
+}
+
+// dialProxy opens a connection to addr that tunnels through the proxy
+// described by proxyURL, as returned by the Dialer's Proxy field. The
+// returned net.Conn carries traffic to addr exactly as if it had been
+// dialed directly.
+func (d *Dialer) dialProxy(ctx context.Context, proxyURL *url.URL, addr string) (net.Conn, error) {
+	switch proxyURL.Scheme {
+	case "socks5", "socks5h":
+		return d.dialSocks5(ctx, proxyURL, addr)
+	case "http", "https":
+		return d.dialConnectProxy(ctx, proxyURL, addr)
+	default:
+		return nil, fmt.Errorf("websocket: unsupported proxy scheme %q", proxyURL.Scheme)
+	}
+}
+
+// applyHandshakeDeadline sets conn's deadline from ctx's, if it has one, so
+// a proxy that stalls mid-handshake cannot hang the dial forever: ctx is
+// derived from Dialer.HandshakeTimeout by Dial, but the proxy handshake
+// I/O below talks to conn directly and never observes ctx cancellation on
+// its own.
+func applyHandshakeDeadline(ctx context.Context, conn net.Conn) {
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+}
+
+// dialConnectProxy tunnels to addr through an HTTP(S) proxy using the
+// CONNECT method (RFC 7231 Section 4.3.6).
+func (d *Dialer) dialConnectProxy(ctx context.Context, proxyURL *url.URL, addr string) (net.Conn, error) {
+	proxyAddr := proxyURL.Host
+	if _, _, err := net.SplitHostPort(proxyAddr); err != nil {
+		if proxyURL.Scheme == "https" {
+			proxyAddr = net.JoinHostPort(proxyAddr, "443")
+		} else {
+			proxyAddr = net.JoinHostPort(proxyAddr, "80")
+		}
+	}
+
+	conn, err := d.dialNet(ctx, "tcp", proxyAddr)
+	if err != nil {
+		return nil, err
+	}
+	applyHandshakeDeadline(ctx, conn)
+
+	if proxyURL.Scheme == "https" {
+		cfg := d.TLSClientConfig
+		if cfg == nil {
+			cfg = &tls.Config{}
+		}
+		if cfg.ServerName == "" {
+			cfg = cfg.Clone()
+			cfg.ServerName = proxyURL.Hostname()
+		}
+		tlsConn := tls.Client(conn, cfg)
+		if err := tlsConn.Handshake(); err != nil {
+			conn.Close()
+			return nil, err
+		}
+		conn = tlsConn
+	}
+
+	req := &http.Request{
+		Method: "CONNECT",
+		URL:    &url.URL{Opaque: addr},
+		Host:   addr,
+		Header: make(http.Header),
+	}
+	if proxyURL.User != nil {
+		password, _ := proxyURL.User.Password()
+		auth := base64.StdEncoding.EncodeToString([]byte(proxyURL.User.Username() + ":" + password))
+		req.Header.Set("Proxy-Authorization", "Basic "+auth)
+	}
+	if err := req.Write(conn); err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	br := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(br, req)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		conn.Close()
+		return nil, fmt.Errorf("websocket: proxy CONNECT to %s failed: %s", addr, resp.Status)
+	}
+	if br.Buffered() > 0 {
+		conn.Close()
+		return nil, errors.New("websocket: proxy sent data before CONNECT tunnel was established")
+	}
+
+	return conn, nil
+}
+
+// SOCKS5 constants from RFC 1928.
+const (
+	socks5Version              = 0x05
+	socks5MethodNoAuth         = 0x00
+	socks5MethodUserPass       = 0x02
+	socks5MethodNoneAcceptable = 0xff
+	socks5CmdConnect           = 0x01
+	socks5AtypIPv4             = 0x01
+	socks5AtypDomain           = 0x03
+	socks5AtypIPv6             = 0x04
+)
+
+// dialSocks5 tunnels to addr through a SOCKS5 proxy (RFC 1928), using
+// username/password authentication (RFC 1929) when proxyURL carries
+// credentials.
+func (d *Dialer) dialSocks5(ctx context.Context, proxyURL *url.URL, addr string) (net.Conn, error) {
+	conn, err := d.dialNet(ctx, "tcp", proxyURL.Host)
+	if err != nil {
+		return nil, err
+	}
+	applyHandshakeDeadline(ctx, conn)
+	if err := socks5Handshake(conn, proxyURL, addr); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return conn, nil
+}
+
+func socks5Handshake(conn net.Conn, proxyURL *url.URL, addr string) error {
+	methods := []byte{socks5MethodNoAuth}
+	if proxyURL.User != nil {
+		methods = []byte{socks5MethodUserPass, socks5MethodNoAuth}
+	}
+	hello := append([]byte{socks5Version, byte(len(methods))}, methods...)
+	if _, err := conn.Write(hello); err != nil {
+		return err
+	}
+
+	reply := make([]byte, 2)
+	if _, err := readFull(conn, reply); err != nil {
+		return err
+	}
+	if reply[0] != socks5Version {
+		return errors.New("websocket: unexpected SOCKS5 version in method reply")
+	}
+	switch reply[1] {
+	case socks5MethodNoAuth:
+	case socks5MethodUserPass:
+		if err := socks5Authenticate(conn, proxyURL); err != nil {
+			return err
+		}
+	case socks5MethodNoneAcceptable:
+		return errors.New("websocket: SOCKS5 proxy rejected all authentication methods")
+	default:
+		return errors.New("websocket: SOCKS5 proxy selected an unsupported authentication method")
+	}
+
+	host, portStr, err := net.SplitHostPort(addr)
+	if err != nil {
+		return err
+	}
+	port, err := parsePort(portStr)
+	if err != nil {
+		return err
+	}
+
+	req := []byte{socks5Version, socks5CmdConnect, 0x00}
+	req = append(req, socks5EncodeAddr(host)...)
+	req = append(req, byte(port>>8), byte(port))
+	if _, err := conn.Write(req); err != nil {
+		return err
+	}
+
+	head := make([]byte, 4)
+	if _, err := readFull(conn, head); err != nil {
+		return err
+	}
+	if head[0] != socks5Version {
+		return errors.New("websocket: unexpected SOCKS5 version in connect reply")
+	}
+	if head[1] != 0x00 {
+		return fmt.Errorf("websocket: SOCKS5 proxy refused connection: code %d", head[1])
+	}
+
+	var addrLen int
+	switch head[3] {
+	case socks5AtypIPv4:
+		addrLen = net.IPv4len
+	case socks5AtypIPv6:
+		addrLen = net.IPv6len
+	case socks5AtypDomain:
+		n := make([]byte, 1)
+		if _, err := readFull(conn, n); err != nil {
+			return err
+		}
+		addrLen = int(n[0])
+	default:
+		return errors.New("websocket: SOCKS5 proxy returned an unknown address type")
+	}
+	// Bound address and port; the tunnel is already usable without them.
+	if _, err := readFull(conn, make([]byte, addrLen+2)); err != nil {
+		return err
+	}
+	return nil
+}
+
+func socks5Authenticate(conn net.Conn, proxyURL *url.URL) error {
+	username := proxyURL.User.Username()
+	password, _ := proxyURL.User.Password()
+	req := []byte{0x01, byte(len(username))}
+	req = append(req, username...)
+	req = append(req, byte(len(password)))
+	req = append(req, password...)
+	if _, err := conn.Write(req); err != nil {
+		return err
+	}
+	reply := make([]byte, 2)
+	if _, err := readFull(conn, reply); err != nil {
+		return err
+	}
+	if reply[1] != 0x00 {
+		return errors.New("websocket: SOCKS5 proxy rejected username/password authentication")
+	}
+	return nil
+}
+
+func socks5EncodeAddr(host string) []byte {
+	if ip := net.ParseIP(host); ip != nil {
+		if ip4 := ip.To4(); ip4 != nil {
+			return append([]byte{socks5AtypIPv4}, ip4...)
+		}
+		return append([]byte{socks5AtypIPv6}, ip.To16()...)
+	}
+	return append([]byte{socks5AtypDomain, byte(len(host))}, host...)
+}
+
+func parsePort(s string) (int, error) {
+	n := 0
+	if s == "" {
+		return 0, errors.New("websocket: empty port")
+	}
+	for _, c := range s {
+		if c < '0' || c > '9' {
+			return 0, fmt.Errorf("websocket: invalid port %q", s)
+		}
+		n = n*10 + int(c-'0')
+	}
+	if n == 0 || n > 65535 {
+		return 0, fmt.Errorf("websocket: invalid port %q", s)
+	}
+	return n, nil
+}
+
+func readFull(conn net.Conn, buf []byte) (int, error) {
+	n := 0
+	for n < len(buf) {
+		k, err := conn.Read(buf[n:])
+		n += k
+		if err != nil {
+			return n, err
+		}
+	}
+	return n, nil
+}
@@ -0,0 +1,386 @@
+// Copyright 2013 Gary Burd
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package websocket
+
+import (
+	"bufio"
+	"context"
+	"encoding/base64"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeSocks5Server accepts a single connection, runs just enough of the
+// SOCKS5 protocol (RFC 1928/1929) to satisfy socks5Handshake, then echoes
+// whatever is written to it back to the caller so the test can confirm the
+// tunnel carries application data once established.
+func fakeSocks5Server(t *testing.T, requireAuth bool) (addr string, done <-chan error) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Listen() returned %v", err)
+	}
+	errc := make(chan error, 1)
+	go func() {
+		errc <- func() error {
+			defer ln.Close()
+			conn, err := ln.Accept()
+			if err != nil {
+				return err
+			}
+			defer conn.Close()
+			br := bufio.NewReader(conn)
+
+			hdr := make([]byte, 2)
+			if _, err := io.ReadFull(br, hdr); err != nil {
+				return err
+			}
+			methods := make([]byte, hdr[1])
+			if _, err := io.ReadFull(br, methods); err != nil {
+				return err
+			}
+			method := byte(socks5MethodNoAuth)
+			if requireAuth {
+				method = socks5MethodUserPass
+			}
+			if _, err := conn.Write([]byte{socks5Version, method}); err != nil {
+				return err
+			}
+			if requireAuth {
+				authHdr := make([]byte, 2)
+				if _, err := io.ReadFull(br, authHdr); err != nil {
+					return err
+				}
+				uname := make([]byte, authHdr[1])
+				if _, err := io.ReadFull(br, uname); err != nil {
+					return err
+				}
+				plenb := make([]byte, 1)
+				if _, err := io.ReadFull(br, plenb); err != nil {
+					return err
+				}
+				passwd := make([]byte, plenb[0])
+				if _, err := io.ReadFull(br, passwd); err != nil {
+					return err
+				}
+				status := byte(0x00)
+				if string(uname) != "alice" || string(passwd) != "secret" {
+					status = 0x01
+				}
+				if _, err := conn.Write([]byte{0x01, status}); err != nil {
+					return err
+				}
+				if status != 0x00 {
+					return nil
+				}
+			}
+
+			req := make([]byte, 4)
+			if _, err := io.ReadFull(br, req); err != nil {
+				return err
+			}
+			switch req[3] {
+			case socks5AtypDomain:
+				lb := make([]byte, 1)
+				if _, err := io.ReadFull(br, lb); err != nil {
+					return err
+				}
+				if _, err := io.ReadFull(br, make([]byte, int(lb[0])+2)); err != nil {
+					return err
+				}
+			case socks5AtypIPv4:
+				if _, err := io.ReadFull(br, make([]byte, net.IPv4len+2)); err != nil {
+					return err
+				}
+			default:
+				if _, err := io.ReadFull(br, make([]byte, net.IPv6len+2)); err != nil {
+					return err
+				}
+			}
+			reply := append([]byte{socks5Version, 0x00, 0x00, socks5AtypIPv4}, 0, 0, 0, 0, 0, 0)
+			if _, err := conn.Write(reply); err != nil {
+				return err
+			}
+
+			_, err = io.Copy(conn, br)
+			return err
+		}()
+	}()
+	return ln.Addr().String(), errc
+}
+
+func TestDialSocks5(t *testing.T) {
+	addr, done := fakeSocks5Server(t, false)
+	d := &Dialer{}
+	conn, err := d.dialSocks5(context.Background(), &url.URL{Scheme: "socks5", Host: addr}, "example.com:80")
+	if err != nil {
+		t.Fatalf("dialSocks5() returned %v", err)
+	}
+	defer conn.Close()
+
+	want := []byte("hello through the tunnel")
+	if _, err := conn.Write(want); err != nil {
+		t.Fatalf("Write() returned %v", err)
+	}
+	got := make([]byte, len(want))
+	if _, err := io.ReadFull(conn, got); err != nil {
+		t.Fatalf("ReadFull() returned %v", err)
+	}
+	if string(got) != string(want) {
+		t.Fatalf("echo mismatch: got %q, want %q", got, want)
+	}
+	conn.Close()
+	if err := <-done; err != nil && err != io.EOF {
+		t.Fatalf("fake SOCKS5 server returned %v", err)
+	}
+}
+
+func TestDialSocks5WithAuth(t *testing.T) {
+	addr, done := fakeSocks5Server(t, true)
+	d := &Dialer{}
+	u := &url.URL{Scheme: "socks5", Host: addr, User: url.UserPassword("alice", "secret")}
+	conn, err := d.dialSocks5(context.Background(), u, "example.com:80")
+	if err != nil {
+		t.Fatalf("dialSocks5() returned %v", err)
+	}
+	conn.Close()
+	if err := <-done; err != nil && err != io.EOF {
+		t.Fatalf("fake SOCKS5 server returned %v", err)
+	}
+}
+
+func TestDialSocks5BadAuth(t *testing.T) {
+	addr, done := fakeSocks5Server(t, true)
+	d := &Dialer{}
+	u := &url.URL{Scheme: "socks5", Host: addr, User: url.UserPassword("alice", "wrong")}
+	_, err := d.dialSocks5(context.Background(), u, "example.com:80")
+	if err == nil {
+		t.Fatalf("dialSocks5() returned nil error, want authentication failure")
+	}
+	<-done
+}
+
+// fakeConnectProxyServer accepts a single connection, handles the HTTP
+// CONNECT request (RFC 7231 Section 4.3.6) dialConnectProxy sends, checks
+// Proxy-Authorization when wantUser is non-empty, then splices the
+// connection through to the real address given in the CONNECT request so
+// traffic beyond the tunnel (here, a full WebSocket handshake) behaves
+// exactly as if dialed directly.
+func fakeConnectProxyServer(t *testing.T, wantUser, wantPassword string) (addr string) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Listen() returned %v", err)
+	}
+	go func() {
+		defer ln.Close()
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+
+		br := bufio.NewReader(conn)
+		req, err := http.ReadRequest(br)
+		if err != nil {
+			return
+		}
+		if wantUser != "" {
+			user, password, ok := parseProxyAuthorization(req.Header.Get("Proxy-Authorization"))
+			if !ok || user != wantUser || password != wantPassword {
+				conn.Write([]byte("HTTP/1.1 407 Proxy Authentication Required\r\n\r\n"))
+				return
+			}
+		}
+
+		backend, err := net.Dial("tcp", req.Host)
+		if err != nil {
+			conn.Write([]byte("HTTP/1.1 502 Bad Gateway\r\n\r\n"))
+			return
+		}
+		defer backend.Close()
+		if _, err := conn.Write([]byte("HTTP/1.1 200 Connection Established\r\n\r\n")); err != nil {
+			return
+		}
+
+		var wg sync.WaitGroup
+		wg.Add(2)
+		go func() { defer wg.Done(); io.Copy(backend, br) }()
+		go func() { defer wg.Done(); io.Copy(conn, backend) }()
+		wg.Wait()
+	}()
+	return ln.Addr().String()
+}
+
+func parseProxyAuthorization(header string) (user, password string, ok bool) {
+	const prefix = "Basic "
+	if !strings.HasPrefix(header, prefix) {
+		return "", "", false
+	}
+	decoded, err := base64.StdEncoding.DecodeString(header[len(prefix):])
+	if err != nil {
+		return "", "", false
+	}
+	user, password, ok = strings.Cut(string(decoded), ":")
+	return user, password, ok
+}
+
+// echoServer upgrades every request and copies each message it reads back
+// to the writer, so a round trip through it confirms the connection
+// carries WebSocket traffic correctly end to end.
+func echoServer(t *testing.T) *httptest.Server {
+	upgrader := Upgrader{}
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		c, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			t.Errorf("Upgrade() returned %v", err)
+			return
+		}
+		defer c.Close()
+		for {
+			messageType, r, err := c.NextReader()
+			if err != nil {
+				return
+			}
+			p, err := ioutil.ReadAll(r)
+			if err != nil {
+				return
+			}
+			if err := c.WriteMessage(messageType, p); err != nil {
+				return
+			}
+		}
+	}))
+}
+
+func TestDialConnectProxy(t *testing.T) {
+	srv := echoServer(t)
+	defer srv.Close()
+
+	proxyAddr := fakeConnectProxyServer(t, "", "")
+	d := &Dialer{Proxy: func(*http.Request) (*url.URL, error) {
+		return &url.URL{Scheme: "http", Host: proxyAddr}, nil
+	}}
+
+	wsURL := "ws" + strings.TrimPrefix(srv.URL, "http")
+	c, _, err := d.Dial(wsURL, nil)
+	if err != nil {
+		t.Fatalf("Dial() returned %v", err)
+	}
+	defer c.Close()
+
+	want := []byte("hello through the tunnel")
+	if err := c.WriteMessage(OpBinary, want); err != nil {
+		t.Fatalf("WriteMessage() returned %v", err)
+	}
+	_, r, err := c.NextReader()
+	if err != nil {
+		t.Fatalf("NextReader() returned %v", err)
+	}
+	got, err := ioutil.ReadAll(r)
+	if err != nil {
+		t.Fatalf("ReadAll() returned %v", err)
+	}
+	if string(got) != string(want) {
+		t.Fatalf("echo mismatch: got %q, want %q", got, want)
+	}
+}
+
+func TestDialConnectProxyWithAuth(t *testing.T) {
+	srv := echoServer(t)
+	defer srv.Close()
+
+	proxyAddr := fakeConnectProxyServer(t, "alice", "secret")
+	proxyURL := &url.URL{Scheme: "http", Host: proxyAddr, User: url.UserPassword("alice", "secret")}
+	d := &Dialer{Proxy: func(*http.Request) (*url.URL, error) { return proxyURL, nil }}
+
+	wsURL := "ws" + strings.TrimPrefix(srv.URL, "http")
+	c, _, err := d.Dial(wsURL, nil)
+	if err != nil {
+		t.Fatalf("Dial() returned %v", err)
+	}
+	c.Close()
+}
+
+// stallingConnectProxyServer accepts a single connection, reads the CONNECT
+// request, then never replies, so dialConnectProxy's handshake I/O blocks
+// forever unless bounded by a deadline.
+func stallingConnectProxyServer(t *testing.T) (addr string) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Listen() returned %v", err)
+	}
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		// Read and discard the CONNECT request without ever replying,
+		// until the client gives up and closes its end.
+		io.Copy(ioutil.Discard, conn)
+	}()
+	t.Cleanup(func() { ln.Close() })
+	return ln.Addr().String()
+}
+
+// TestDialConnectProxyHandshakeTimeout checks that a CONNECT proxy which
+// accepts the TCP connection but never responds to the CONNECT request is
+// bounded by Dialer.HandshakeTimeout, rather than hanging Dial forever.
+func TestDialConnectProxyHandshakeTimeout(t *testing.T) {
+	proxyAddr := stallingConnectProxyServer(t)
+	d := &Dialer{
+		HandshakeTimeout: 50 * time.Millisecond,
+		Proxy: func(*http.Request) (*url.URL, error) {
+			return &url.URL{Scheme: "http", Host: proxyAddr}, nil
+		},
+	}
+
+	done := make(chan error, 1)
+	go func() {
+		_, _, err := d.Dial("ws://example.com/", nil)
+		done <- err
+	}()
+
+	select {
+	case err := <-done:
+		if err == nil {
+			t.Fatal("Dial() returned nil error, want a timeout from the stalled proxy handshake")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Dial() did not return within 5s of a stalled proxy handshake")
+	}
+}
+
+func TestDialConnectProxyBadAuth(t *testing.T) {
+	srv := echoServer(t)
+	defer srv.Close()
+
+	proxyAddr := fakeConnectProxyServer(t, "alice", "secret")
+	proxyURL := &url.URL{Scheme: "http", Host: proxyAddr, User: url.UserPassword("alice", "wrong")}
+	d := &Dialer{Proxy: func(*http.Request) (*url.URL, error) { return proxyURL, nil }}
+
+	wsURL := "ws" + strings.TrimPrefix(srv.URL, "http")
+	_, _, err := d.Dial(wsURL, nil)
+	if err == nil {
+		t.Fatalf("Dial() returned nil error, want proxy authentication failure")
+	}
+}
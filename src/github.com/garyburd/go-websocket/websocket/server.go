@@ -0,0 +1,176 @@
+// Copyright 2013 Gary Burd
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package websocket
+
+import (
+	"bufio"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// HandshakeError describes an error with the handshake from the peer.
+type HandshakeError struct {
+	message string
+}
+
+func (e HandshakeError) Error() string { return e.message }
+
+// Upgrader upgrades an HTTP server connection to the WebSocket protocol.
+type Upgrader struct {
+	// ReadBufferSize and WriteBufferSize specify I/O buffer sizes in
+	// bytes. If a buffer size is zero, then a default value of 4096 is
+	// used.
+	ReadBufferSize, WriteBufferSize int
+
+	// Subprotocols specifies the server's supported protocols in order
+	// of preference. If this field is set, then the Upgrade method
+	// negotiates a subprotocol by selecting the first match in this list
+	// with a protocol requested by the client.
+	Subprotocols []string
+
+	// Error specifies the function for generating HTTP error responses.
+	// If Error is nil, then http.Error is used to generate the HTTP
+	// response.
+	Error func(w http.ResponseWriter, r *http.Request, status int, reason error)
+
+	// CheckOrigin returns true if the request Origin header is
+	// acceptable. If CheckOrigin is nil, then a safe default is used:
+	// return false if the Origin request header is present and the
+	// origin host is not equal to the Host request header.
+	CheckOrigin func(r *http.Request) bool
+
+	// EnableCompression specifies whether the server should attempt to
+	// negotiate the permessage-deflate extension (RFC 7692) with
+	// clients that offer it.
+	EnableCompression bool
+}
+
+func (u *Upgrader) returnError(w http.ResponseWriter, r *http.Request, status int, reason string) (*Conn, error) {
+	err := HandshakeError{reason}
+	if u.Error != nil {
+		u.Error(w, r, status, err)
+	} else {
+		w.Header().Set("Sec-Websocket-Version", "13")
+		http.Error(w, http.StatusText(status), status)
+	}
+	return nil, err
+}
+
+func (u *Upgrader) selectSubprotocol(r *http.Request) string {
+	if len(u.Subprotocols) == 0 {
+		return ""
+	}
+	requested := strings.Split(r.Header.Get("Sec-Websocket-Protocol"), ",")
+	for _, want := range u.Subprotocols {
+		for _, got := range requested {
+			if strings.EqualFold(want, strings.TrimSpace(got)) {
+				return want
+			}
+		}
+	}
+	return ""
+}
+
+func (u *Upgrader) checkOrigin(r *http.Request) bool {
+	if u.CheckOrigin != nil {
+		return u.CheckOrigin(r)
+	}
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return true
+	}
+	u2, err := url.Parse(origin)
+	if err != nil {
+		return false
+	}
+	return strings.EqualFold(u2.Host, r.Host)
+}
+
+// Upgrade upgrades the HTTP server connection to the WebSocket protocol.
+//
+// The responseHeader is included in the response to the client's upgrade
+// request. Use the responseHeader to specify cookies (Set-Cookie) and the
+// application negotiated subprotocol (Sec-WebSocket-Protocol).
+func (u *Upgrader) Upgrade(w http.ResponseWriter, r *http.Request, responseHeader http.Header) (*Conn, error) {
+	if r.Method != "GET" {
+		return u.returnError(w, r, http.StatusMethodNotAllowed, "websocket: method not GET")
+	}
+	if !tokenListContainsValue(r.Header.Get("Connection"), "upgrade") {
+		return u.returnError(w, r, http.StatusBadRequest, "websocket: 'upgrade' token not found in 'Connection' header")
+	}
+	if !tokenListContainsValue(r.Header.Get("Upgrade"), "websocket") {
+		return u.returnError(w, r, http.StatusBadRequest, "websocket: 'websocket' token not found in 'Upgrade' header")
+	}
+	if r.Header.Get("Sec-Websocket-Version") != "13" {
+		return u.returnError(w, r, http.StatusBadRequest, "websocket: unsupported version: 13 not found in 'Sec-Websocket-Version' header")
+	}
+	challengeKey := r.Header.Get("Sec-Websocket-Key")
+	if challengeKey == "" {
+		return u.returnError(w, r, http.StatusBadRequest, "websocket: not a websocket handshake: 'Sec-Websocket-Key' header is missing or blank")
+	}
+	if !u.checkOrigin(r) {
+		return u.returnError(w, r, http.StatusForbidden, "websocket: request origin not allowed by Upgrader.CheckOrigin")
+	}
+
+	hj, ok := w.(http.Hijacker)
+	if !ok {
+		return u.returnError(w, r, http.StatusInternalServerError, "websocket: response does not implement http.Hijacker")
+	}
+	var brw *bufio.ReadWriter
+	netConn, brw, err := hj.Hijack()
+	if err != nil {
+		return u.returnError(w, r, http.StatusInternalServerError, err.Error())
+	}
+	if brw.Reader.Buffered() > 0 {
+		netConn.Close()
+		return u.returnError(w, r, http.StatusInternalServerError, "websocket: client sent data before handshake is complete")
+	}
+
+	c := newConn(netConn, true, u.ReadBufferSize, u.WriteBufferSize)
+	c.subprotocol = u.selectSubprotocol(r)
+
+	var negotiatedExtension string
+	if u.EnableCompression {
+		if offer, ok := permessageDeflateOffer(parseExtensionHeader(r.Header.Get("Sec-Websocket-Extensions"))); ok {
+			c.permessageDeflate = true
+			negotiatedExtension = acceptPermessageDeflate(offer)
+		}
+	}
+
+	p := bufio.NewWriter(netConn)
+	p.WriteString("HTTP/1.1 101 Switching Protocols\r\n")
+	p.WriteString("Upgrade: websocket\r\n")
+	p.WriteString("Connection: Upgrade\r\n")
+	p.WriteString("Sec-WebSocket-Accept: " + computeAcceptKey(challengeKey) + "\r\n")
+	if c.subprotocol != "" {
+		p.WriteString("Sec-WebSocket-Protocol: " + c.subprotocol + "\r\n")
+	}
+	if negotiatedExtension != "" {
+		p.WriteString("Sec-WebSocket-Extensions: " + negotiatedExtension + "\r\n")
+	}
+	for k, vs := range responseHeader {
+		for _, v := range vs {
+			p.WriteString(k + ": " + v + "\r\n")
+		}
+	}
+	p.WriteString("\r\n")
+	if err := p.Flush(); err != nil {
+		netConn.Close()
+		return nil, err
+	}
+
+	return c, nil
+}
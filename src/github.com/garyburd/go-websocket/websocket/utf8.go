@@ -0,0 +1,112 @@
+// Copyright 2013 Gary Burd
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package websocket
+
+import (
+	"errors"
+	"io"
+	"time"
+	"unicode/utf8"
+)
+
+// ErrInvalidUTF8 is returned by the reader from NextReader when a text
+// message does not contain valid UTF-8, as required by Section 8.1 of
+// RFC 6455. The connection fails a close frame carrying
+// CloseInvalidFramePayloadData back to the peer before this error is
+// returned.
+var ErrInvalidUTF8 = errors.New("websocket: invalid UTF-8 in text message")
+
+// completeUTF8Prefix returns the length of the longest prefix of data that
+// is guaranteed not to end in the middle of a multi-byte rune. Any bytes
+// past that length are the start of a rune whose continuation bytes may
+// not have arrived yet, and are held back for the next call.
+func completeUTF8Prefix(data []byte) int {
+	n := len(data)
+	for back := 1; back <= utf8.UTFMax && back <= n; back++ {
+		b := data[n-back]
+		if b&0xc0 == 0x80 {
+			// Continuation byte; keep looking further back for its lead byte.
+			continue
+		}
+		want := 1
+		switch {
+		case b&0xe0 == 0xc0:
+			want = 2
+		case b&0xf0 == 0xe0:
+			want = 3
+		case b&0xf8 == 0xf0:
+			want = 4
+		}
+		if want > back {
+			return n - back
+		}
+		return n
+	}
+	return n
+}
+
+// utf8Reader validates that a text message is well-formed UTF-8 as its
+// bytes stream through, without requiring the whole message to be
+// buffered and without assuming rune boundaries line up with frame or
+// Read boundaries.
+type utf8Reader struct {
+	c    *Conn
+	r    io.Reader
+	buf  []byte // validated bytes not yet returned to the caller
+	pend []byte // possibly-incomplete trailing rune held back from the last Read
+	err  error
+}
+
+func newUTF8Reader(c *Conn, r io.Reader) io.Reader {
+	return &utf8Reader{c: c, r: r}
+}
+
+func (u *utf8Reader) invalid() (int, error) {
+	u.c.WriteControl(OpClose, FormatCloseMessage(CloseInvalidFramePayloadData, ""), time.Now().Add(writeWait))
+	u.err = ErrInvalidUTF8
+	return 0, u.err
+}
+
+func (u *utf8Reader) Read(p []byte) (int, error) {
+	for len(u.buf) == 0 && u.err == nil {
+		tmp := make([]byte, 4096)
+		n, err := u.r.Read(tmp)
+		if n > 0 {
+			data := append(u.pend, tmp[:n]...)
+			validLen := completeUTF8Prefix(data)
+			if !utf8.Valid(data[:validLen]) {
+				return u.invalid()
+			}
+			u.buf = data[:validLen]
+			u.pend = append([]byte(nil), data[validLen:]...)
+		}
+		if err != nil {
+			if err == io.EOF {
+				if len(u.pend) > 0 {
+					return u.invalid()
+				}
+				u.err = io.EOF
+			} else {
+				u.err = err
+			}
+		}
+	}
+	if len(u.buf) > 0 {
+		n := copy(p, u.buf)
+		u.buf = u.buf[n:]
+		return n, nil
+	}
+	return 0, u.err
+}
@@ -0,0 +1,155 @@
+// Copyright 2013 Gary Burd
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package websocket
+
+import (
+	"bytes"
+	"io/ioutil"
+	"testing"
+	"time"
+)
+
+// writeText is a small helper that writes a single OpText message,
+// possibly split across multiple frames by fragmenting the write at
+// fragmentAt, bypassing the outgoing UTF-8 check messageWriter never
+// performs so the test can exercise what the reader does with malformed
+// bytes straight off the wire.
+func writeText(t *testing.T, c *Conn, data []byte, fragmentAt int) {
+	t.Helper()
+	w, err := c.NextWriter(OpText)
+	if err != nil {
+		t.Fatalf("NextWriter() returned %v", err)
+	}
+	if fragmentAt > 0 && fragmentAt < len(data) {
+		if _, err := w.Write(data[:fragmentAt]); err != nil {
+			t.Fatalf("Write() returned %v", err)
+		}
+		if err := w.(*messageWriter).flush(false); err != nil {
+			t.Fatalf("flush() returned %v", err)
+		}
+		data = data[fragmentAt:]
+	}
+	if _, err := w.Write(data); err != nil {
+		t.Fatalf("Write() returned %v", err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("Close() returned %v", err)
+	}
+}
+
+func TestNextReaderValidUTF8(t *testing.T) {
+	text := []byte("plain ascii, then some accents: caf\xc3\xa9, and an emoji: \xf0\x9f\x98\x80!")
+	for _, fragmentAt := range []int{0, 1, len(text) - 1, len(text) / 2} {
+		var connBuf bytes.Buffer
+		wc := newConn(fakeNetConn{Reader: nil, Writer: &connBuf}, false, 1024, 1024)
+		writeText(t, wc, text, fragmentAt)
+
+		rc := newConn(fakeNetConn{Reader: bytes.NewReader(connBuf.Bytes()), Writer: ioutil.Discard}, true, 1024, 1024)
+		_, r, err := rc.NextReader()
+		if err != nil {
+			t.Fatalf("fragmentAt=%d: NextReader() returned %v", fragmentAt, err)
+		}
+		got, err := ioutil.ReadAll(r)
+		if err != nil {
+			t.Fatalf("fragmentAt=%d: ReadAll() returned %v", fragmentAt, err)
+		}
+		if !bytes.Equal(got, text) {
+			t.Fatalf("fragmentAt=%d: got %q, want %q", fragmentAt, got, text)
+		}
+	}
+}
+
+func TestNextReaderInvalidUTF8(t *testing.T) {
+	// \xff is never valid in any position of a UTF-8 byte sequence.
+	bad := []byte("hello \xff world")
+	var connBuf bytes.Buffer
+	wc := newConn(fakeNetConn{Reader: nil, Writer: &connBuf}, false, 1024, 1024)
+	writeText(t, wc, bad, 0)
+
+	rc := newConn(fakeNetConn{Reader: bytes.NewReader(connBuf.Bytes()), Writer: ioutil.Discard}, true, 1024, 1024)
+	_, r, err := rc.NextReader()
+	if err != nil {
+		t.Fatalf("NextReader() returned %v", err)
+	}
+	if _, err := ioutil.ReadAll(r); err != ErrInvalidUTF8 {
+		t.Fatalf("ReadAll() returned %v, want ErrInvalidUTF8", err)
+	}
+}
+
+func TestNextReaderValidUTF8SplitAcrossFrames(t *testing.T) {
+	// The 2-byte encoding of 'é' (0xc3 0xa9) split across a frame boundary
+	// must still validate correctly once both halves have arrived.
+	text := []byte("caf\xc3\xa9")
+	var connBuf bytes.Buffer
+	wc := newConn(fakeNetConn{Reader: nil, Writer: &connBuf}, false, 1024, 16)
+	writeText(t, wc, text, len(text)-1)
+
+	rc := newConn(fakeNetConn{Reader: bytes.NewReader(connBuf.Bytes()), Writer: ioutil.Discard}, true, 1024, 1024)
+	_, r, err := rc.NextReader()
+	if err != nil {
+		t.Fatalf("NextReader() returned %v", err)
+	}
+	got, err := ioutil.ReadAll(r)
+	if err != nil {
+		t.Fatalf("ReadAll() returned %v", err)
+	}
+	if !bytes.Equal(got, text) {
+		t.Fatalf("got %q, want %q", got, text)
+	}
+}
+
+func TestValidCloseCode(t *testing.T) {
+	tests := []struct {
+		code int
+		want bool
+	}{
+		{999, false},
+		{1000, true},
+		{1003, true},
+		{1004, false},
+		{1005, false},
+		{1006, false},
+		{1007, true},
+		{1011, true},
+		{1012, false},
+		{2999, false},
+		{3000, true},
+		{4999, true},
+		{5000, false},
+	}
+	for _, tt := range tests {
+		if got := validCloseCode(tt.code); got != tt.want {
+			t.Errorf("validCloseCode(%d) = %v, want %v", tt.code, got, tt.want)
+		}
+	}
+}
+
+func TestNextReaderInvalidCloseCode(t *testing.T) {
+	var connBuf bytes.Buffer
+	wc := newConn(fakeNetConn{Reader: nil, Writer: &connBuf}, false, 1024, 1024)
+	if err := wc.WriteControl(OpClose, FormatCloseMessage(1005, ""), time.Now().Add(writeWait)); err != nil {
+		t.Fatalf("WriteControl() returned %v", err)
+	}
+
+	rc := newConn(fakeNetConn{Reader: bytes.NewReader(connBuf.Bytes()), Writer: ioutil.Discard}, true, 1024, 1024)
+	_, _, err := rc.NextReader()
+	closeErr, ok := err.(*CloseError)
+	if !ok {
+		t.Fatalf("NextReader() returned %v, want *CloseError", err)
+	}
+	if closeErr.Code != CloseProtocolError {
+		t.Fatalf("NextReader() returned close code %d, want CloseProtocolError", closeErr.Code)
+	}
+}
@@ -0,0 +1,146 @@
+// Copyright 2013 Gary Burd
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package websocket
+
+import (
+	"context"
+	"errors"
+)
+
+// ErrWriteQueueNotEnabled is returned by WriteMessageAsync and Flush when
+// EnableWriteQueue has not been called on the connection.
+var ErrWriteQueueNotEnabled = errors.New("websocket: write queue not enabled")
+
+// ErrWriteQueueClosed is returned by WriteMessageAsync and Flush once Close
+// has been called, instead of sending on the write queue: writeQueue is
+// never itself closed (a send on a closed channel panics), so this is the
+// signal that the write-queue goroutine is gone and nothing will ever
+// drain a new send.
+var ErrWriteQueueClosed = errors.New("websocket: write queue closed")
+
+// queuedMessage is either a message queued by WriteMessageAsync, or, when
+// marker is non-nil, a Flush sentinel: the write-queue goroutine closes
+// marker once every message queued ahead of it has been written.
+type queuedMessage struct {
+	messageType int
+	data        []byte
+	marker      chan struct{}
+}
+
+// EnableWriteQueue puts the connection into asynchronous write mode.
+// WriteMessageAsync then enqueues messages onto a channel of the given
+// depth and returns immediately; a dedicated goroutine drains the channel
+// and writes each message with WriteMessage, in the order queued.
+//
+// Control frames written with WriteControl are unaffected by the queue:
+// they always go straight to the wire, so pings, pongs and closes are
+// never stuck waiting behind a backlog of queued data messages.
+//
+// EnableWriteQueue must be called at most once per connection, and before
+// any call to WriteMessageAsync.
+func (c *Conn) EnableWriteQueue(depth int) {
+	if c.writeQueue != nil {
+		panic("websocket: EnableWriteQueue called more than once")
+	}
+	c.writeQueue = make(chan queuedMessage, depth)
+	c.writeQueueDone = make(chan struct{})
+	go c.writeQueueLoop()
+}
+
+func (c *Conn) writeQueueLoop() {
+	for {
+		select {
+		case m := <-c.writeQueue:
+			if m.marker != nil {
+				close(m.marker)
+				continue
+			}
+			if err := c.WriteMessage(m.messageType, m.data); err != nil {
+				c.writeQueueMu.Lock()
+				if c.writeQueueErr == nil {
+					c.writeQueueErr = err
+				}
+				c.writeQueueMu.Unlock()
+			}
+		case <-c.writeQueueDone:
+			return
+		}
+	}
+}
+
+// queueMessage sends m on the write queue, or reports why it could not:
+// a previous write failure, Close having already torn the queue down, or
+// ctx being done while the queue is full. It checks writeQueueDone before
+// sending, so a call made after Close never sends; and since writeQueue is
+// never closed, racing Close can never cause a send on a closed channel.
+func (c *Conn) queueMessage(ctx context.Context, m queuedMessage) error {
+	c.writeQueueMu.Lock()
+	err := c.writeQueueErr
+	c.writeQueueMu.Unlock()
+	if err != nil {
+		return err
+	}
+	select {
+	case <-c.writeQueueDone:
+		return ErrWriteQueueClosed
+	default:
+	}
+	select {
+	case c.writeQueue <- m:
+		return nil
+	case <-c.writeQueueDone:
+		return ErrWriteQueueClosed
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// WriteMessageAsync queues data to be written as a single message of the
+// given type and returns as soon as it is queued, rather than once it has
+// reached the wire. EnableWriteQueue must have been called first.
+//
+// If a previously queued message failed to write, WriteMessageAsync
+// returns that error instead of queuing data, so a broken connection is
+// reported to the caller rather than silently discarding messages.
+func (c *Conn) WriteMessageAsync(messageType int, data []byte) error {
+	if c.writeQueue == nil {
+		return ErrWriteQueueNotEnabled
+	}
+	return c.queueMessage(context.Background(), queuedMessage{messageType: messageType, data: data})
+}
+
+// Flush blocks until every message queued by WriteMessageAsync before
+// this call has been written, or until ctx is done. It returns the first
+// error encountered by the write-queue goroutine, if any.
+func (c *Conn) Flush(ctx context.Context) error {
+	if c.writeQueue == nil {
+		return ErrWriteQueueNotEnabled
+	}
+	marker := make(chan struct{})
+	if err := c.queueMessage(ctx, queuedMessage{marker: marker}); err != nil {
+		return err
+	}
+	select {
+	case <-marker:
+	case <-c.writeQueueDone:
+		return ErrWriteQueueClosed
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	c.writeQueueMu.Lock()
+	err := c.writeQueueErr
+	c.writeQueueMu.Unlock()
+	return err
+}
@@ -0,0 +1,201 @@
+// Copyright 2013 Gary Burd
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package websocket
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"sync"
+	"testing"
+	"time"
+)
+
+// syncBuffer is a bytes.Buffer safe for one writer goroutine at a time
+// plus concurrent readers after the writers are done; the write queue
+// test needs this because WriteMessageAsync and WriteControl can reach
+// the wire from different goroutines (the queue's writer goroutine and
+// the caller's own goroutine, respectively).
+type syncBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func TestWriteQueueConcurrentWriters(t *testing.T) {
+	const goroutines = 8
+	const messagesPerGoroutine = 50
+
+	var wire syncBuffer
+	wc := newConn(fakeNetConn{Reader: nil, Writer: &wire}, false, 1024, 1024)
+	wc.EnableWriteQueue(16)
+
+	var wg sync.WaitGroup
+	for g := 0; g < goroutines; g++ {
+		wg.Add(1)
+		go func(g int) {
+			defer wg.Done()
+			for i := 0; i < messagesPerGoroutine; i++ {
+				payload := []byte(fmt.Sprintf("g%d-m%d", g, i))
+				if err := wc.WriteMessageAsync(OpBinary, payload); err != nil {
+					t.Errorf("WriteMessageAsync() returned %v", err)
+					return
+				}
+				if i%10 == 0 {
+					if err := wc.WriteControl(OpPing, nil, time.Now().Add(writeWait)); err != nil {
+						t.Errorf("WriteControl() returned %v", err)
+						return
+					}
+				}
+			}
+		}(g)
+	}
+	wg.Wait()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := wc.Flush(ctx); err != nil {
+		t.Fatalf("Flush() returned %v", err)
+	}
+
+	rc := newConn(fakeNetConn{Reader: bytes.NewReader(wire.buf.Bytes()), Writer: ioutil.Discard}, true, 1024, 1024)
+	gotBinary := 0
+	gotPing := 0
+	for {
+		opCode, r, err := rc.NextReader()
+		if err != nil {
+			break
+		}
+		if _, err := ioutil.ReadAll(r); err != nil {
+			t.Fatalf("ReadAll() returned %v", err)
+		}
+		switch opCode {
+		case OpBinary:
+			gotBinary++
+		case OpPing:
+			gotPing++
+		default:
+			t.Fatalf("unexpected opcode %d on the wire", opCode)
+		}
+	}
+
+	wantBinary := goroutines * messagesPerGoroutine
+	if gotBinary != wantBinary {
+		t.Errorf("read %d binary messages, want %d", gotBinary, wantBinary)
+	}
+	wantPing := goroutines * ((messagesPerGoroutine + 9) / 10)
+	if gotPing != wantPing {
+		t.Errorf("read %d pings, want %d", gotPing, wantPing)
+	}
+}
+
+// TestWriteQueueCloseDuringWrite closes the connection while another
+// goroutine is still calling WriteMessageAsync, the pattern Handler.Serve
+// uses (a deferred Close racing a reader goroutine that may still be
+// producing). WriteMessageAsync must report ErrWriteQueueClosed once Close
+// wins the race, never panic with a send on a closed channel.
+func TestWriteQueueCloseDuringWrite(t *testing.T) {
+	var wire syncBuffer
+	wc := newConn(fakeNetConn{Reader: nil, Writer: &wire}, false, 1024, 1024)
+	wc.EnableWriteQueue(1)
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 10000; i++ {
+			if err := wc.WriteMessageAsync(OpBinary, []byte("x")); err != nil {
+				return
+			}
+		}
+	}()
+
+	wc.Close()
+	wg.Wait()
+}
+
+// delayWriter pads every physical write with a small fixed delay so a test
+// can reliably observe the write-queue goroutine mid-backlog instead of
+// racing it to completion.
+type delayWriter struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (w *delayWriter) Write(p []byte) (int, error) {
+	time.Sleep(200 * time.Microsecond)
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.buf.Write(p)
+}
+
+// TestWriteQueuePingNotStarved checks that a ping written directly with
+// WriteControl while a large backlog of async messages is still draining
+// reaches the wire well before that backlog finishes, rather than waiting
+// behind all of it.
+func TestWriteQueuePingNotStarved(t *testing.T) {
+	var wire delayWriter
+	wc := newConn(fakeNetConn{Reader: nil, Writer: &wire}, false, 1024, 1024)
+	wc.EnableWriteQueue(4)
+
+	const backlog = 200
+	queued := make(chan struct{})
+	go func() {
+		defer close(queued)
+		for i := 0; i < backlog; i++ {
+			wc.WriteMessageAsync(OpBinary, []byte("backlog"))
+		}
+	}()
+
+	time.Sleep(5 * time.Millisecond) // let the backlog start draining first
+	if err := wc.WriteControl(OpPing, []byte("hi"), time.Now().Add(writeWait)); err != nil {
+		t.Fatalf("WriteControl() returned %v", err)
+	}
+	<-queued // Flush below must not race the goroutine still enqueuing messages
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := wc.Flush(ctx); err != nil {
+		t.Fatalf("Flush() returned %v", err)
+	}
+
+	rc := newConn(fakeNetConn{Reader: bytes.NewReader(wire.buf.Bytes()), Writer: ioutil.Discard}, true, 1024, 1024)
+	pingIndex, total := -1, 0
+	for {
+		opCode, r, err := rc.NextReader()
+		if err != nil {
+			break
+		}
+		if _, err := ioutil.ReadAll(r); err != nil {
+			t.Fatalf("ReadAll() returned %v", err)
+		}
+		if opCode == OpPing {
+			pingIndex = total
+		}
+		total++
+	}
+	if pingIndex < 0 {
+		t.Fatal("ping frame never reached the wire")
+	}
+	if pingIndex > total/2 {
+		t.Fatalf("ping landed at position %d of %d frames; want it well before the backlog drained", pingIndex, total)
+	}
+}